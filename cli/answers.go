@@ -0,0 +1,118 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// applyAnswersJSON reads the JSON object given with --answers-json, which
+// maps flag names to their values, e.g. {"device-type": "raspberrypi4",
+// "hosted-mender": true}, and sets each flag which was not given on the
+// command line. An array sets a flag once per element. With "-" the object
+// is read from stdin, and the remaining prompts read what follows it.
+func (opts *setupOptionsType) applyAnswersJSON(ctx *cli.Context) error {
+	if opts.answersJSON == "" {
+		return nil
+	}
+	var input io.Reader = os.Stdin
+	if opts.answersJSON != "-" {
+		file, err := os.Open(opts.answersJSON)
+		if err != nil {
+			return errors.Wrap(err, "Cannot read the answers")
+		}
+		defer file.Close()
+		input = file
+	}
+	dec := json.NewDecoder(input)
+	dec.UseNumber()
+	answers := map[string]interface{}{}
+	if err := dec.Decode(&answers); err != nil {
+		return errors.Wrap(err, "Invalid JSON answers")
+	}
+	if opts.answersJSON == "-" {
+		// The prompts start on the line after the object.
+		rest, err := ioutil.ReadAll(dec.Buffered())
+		if err != nil {
+			return errors.Wrap(err, "Error reading from stdin.")
+		}
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 &&
+			len(bytes.TrimSpace(rest[:i])) == 0 {
+			rest = rest[i+1:]
+		}
+		opts.promptInput = io.MultiReader(bytes.NewReader(rest), os.Stdin)
+	}
+
+	names := make([]string, 0, len(answers))
+	for name := range answers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "answers-json" {
+			return errors.New("The answers cannot give answers-json")
+		}
+		values, err := answerValues(answers[name])
+		if err != nil {
+			return errors.Wrapf(err, "Invalid answer for %q", name)
+		}
+		if ctx.IsSet(name) {
+			// The command line wins.
+			continue
+		}
+		for _, value := range values {
+			if err := ctx.Set(name, value); err != nil {
+				return errors.Wrapf(err, "Invalid answer for %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// answerValues returns the flag values of a JSON answer.
+func answerValues(answer interface{}) ([]string, error) {
+	switch answer := answer.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{answer}, nil
+	case json.Number:
+		return []string{answer.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(answer)}, nil
+	case []interface{}:
+		values := []string{}
+		for _, element := range answer {
+			if _, ok := element.([]interface{}); ok {
+				return nil, errors.New("nested arrays are not supported")
+			}
+			elementValues, err := answerValues(element)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elementValues...)
+		}
+		return values, nil
+	}
+	return nil, errors.Errorf("unsupported value %v", answer)
+}
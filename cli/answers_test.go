@@ -0,0 +1,102 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupAnswersJSON(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	setupWithAnswers := func(answers string, args ...string) error {
+		stdinR, stdinW, err := os.Pipe()
+		require.NoError(t, err)
+		os.Stdin = stdinR
+		stdinW.WriteString(answers)
+		stdinW.Close()
+		return SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--config", confPath, "--data", tmpDir,
+			"--answers-json", "-"}, args...))
+	}
+
+	require.NoError(t, setupWithAnswers(`{
+		"device-type": "acme-pi",
+		"hosted-mender": true,
+		"tenant-token": "dummy-token",
+		"update-poll": 600,
+		"inventory-poll": "disabled",
+		"retry-poll": 60,
+		"artifact-verify-keys": null
+	}`, "--batch"))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	var config conf.MenderConfigFromFile
+	require.NoError(t, json.Unmarshal(data, &config))
+	require.Len(t, config.Servers, 1)
+	assert.Equal(t, hostedMenderURL, config.Servers[0].ServerURL)
+	assert.Equal(t, "dummy-token", config.TenantToken)
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, inventoryPollDisabled, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, 60, config.RetryPollIntervalSeconds)
+	devType, err := ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=acme-pi\n", string(devType))
+
+	// The command line wins, and the prompts read what follows the answers.
+	require.NoError(t, setupWithAnswers(`{
+		"device-type": "acme-pi",
+		"hosted-mender": false,
+		"demo-server": false,
+		"server-cert": "",
+		"demo-polling": true
+	}
+https://acme.mender.io
+n
+`, "--device-type", "other-pi"))
+	data, err = ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+	devType, err = ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=other-pi\n", string(devType))
+
+	// With --batch, missing answers are an error.
+	err = setupWithAnswers(`{"device-type": "acme-pi"}`, "--batch")
+	assert.ErrorContains(t, err, "non-interactive")
+
+	err = setupWithAnswers(`{"no-such-flag": 1}`)
+	assert.ErrorContains(t, err, `Invalid answer for "no-such-flag"`)
+	err = setupWithAnswers(`{"device-type": {"name": "acme-pi"}}`)
+	assert.ErrorContains(t, err, `Invalid answer for "device-type"`)
+	err = setupWithAnswers(`["acme-pi"]`)
+	assert.ErrorContains(t, err, "Invalid JSON answers")
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// setupSpec is the desired configuration read by the apply command. Being
+// YAML, a JSON spec is accepted too.
+type setupSpec struct {
+	DeviceType        string   `yaml:"device_type"`
+	Servers           []string `yaml:"servers"`
+	ServerCertificate string   `yaml:"server_certificate"`
+	TenantToken       string   `yaml:"tenant_token"`
+	// DataDir holds the device_type file; conf.DefaultDataStore if empty.
+	DataDir string `yaml:"data_dir"`
+	Polls   struct {
+		Update    int `yaml:"update"`
+		Inventory int `yaml:"inventory"`
+		Retry     int `yaml:"retry"`
+	} `yaml:"polls"`
+	Security struct {
+		SkipVerify    bool   `yaml:"skip_verify"`
+		MinTLSVersion string `yaml:"min_tls_version"`
+	} `yaml:"security"`
+}
+
+func applyCommand() *cli.Command {
+	return &cli.Command{
+		Name: "apply",
+		Usage: "Bring the configuration in line with a spec file, writing " +
+			"only what differs from it.",
+		Flags: []cli.Flag{
+			configFlag(),
+			&cli.StringFlag{
+				Name:     "spec",
+				Usage:    "`PATH` to the YAML or JSON spec of the desired configuration.",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "policy-file",
+				Usage: "`PATH` to the system-wide setup policy which the spec must follow.",
+				Value: DefaultPolicyFile,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return applySpecFile(ctx.App.Writer, ctx.String("spec"),
+				ctx.String("config"), ctx.String("policy-file"))
+		},
+	}
+}
+
+func loadSpec(specPath string) (*setupSpec, error) {
+	data, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read spec file %q", specPath)
+	}
+	spec := &setupSpec{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(spec); err != nil {
+		return nil, errors.Wrapf(err, "Invalid spec file %q", specPath)
+	}
+	return spec, nil
+}
+
+// setupOptions returns the options equivalent to the spec, failing for
+// values that setup would have rejected.
+func (spec *setupSpec) setupOptions(configPath string) (*setupOptionsType, error) {
+	if !regexp.MustCompile(validDeviceRegularExpression).
+		MatchString(spec.DeviceType) {
+		return nil, errors.Errorf("Invalid device type %q in spec",
+			spec.DeviceType)
+	}
+	if len(spec.Servers) == 0 {
+		return nil, errors.New("The spec must list at least one server")
+	}
+	for _, serverURL := range spec.Servers {
+		if !isValidServerURL(serverURL) {
+			return nil, errors.Errorf("Invalid server URL %q in spec",
+				serverURL)
+		}
+	}
+	opts := &setupOptionsType{
+		configPath:         configPath,
+		deviceType:         spec.DeviceType,
+		serverURL:          spec.Servers[0],
+		fallbackServers:    spec.Servers[1:],
+		serverCert:         spec.ServerCertificate,
+		tenantToken:        spec.TenantToken,
+		updatePollInterval: defaultUpdatePoll,
+		invPollInterval:    defaultInventoryPoll,
+		retryPollInterval:  defaultRetryPoll,
+		minTLSVersion:      spec.Security.MinTLSVersion,
+	}
+	for _, poll := range []struct {
+		name     string
+		value    int
+		interval *int
+	}{
+		{"update", spec.Polls.Update, &opts.updatePollInterval},
+		{"inventory", spec.Polls.Inventory, &opts.invPollInterval},
+		{"retry", spec.Polls.Retry, &opts.retryPollInterval},
+	} {
+		if poll.value == 0 {
+			continue
+		}
+		if poll.value < minimumPollInterval {
+			return nil, errors.Errorf(
+				"The %s poll interval is %d; the minimum is %d seconds",
+				poll.name, poll.value, minimumPollInterval)
+		}
+		*poll.interval = poll.value
+	}
+	if opts.minTLSVersion != "" {
+		if _, err := parseTLSVersion(opts.minTLSVersion); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// applySpecFile writes the configuration described by the spec at specPath
+// to configPath, together with the device_type file, unless both already
+// match. The spec is subject to the setup policy at policyFile, and the
+// files are written as by setup, backing up the previous configuration.
+func applySpecFile(w io.Writer, specPath, configPath, policyFile string) error {
+	spec, err := loadSpec(specPath)
+	if err != nil {
+		return err
+	}
+	opts, err := spec.setupOptions(configPath)
+	if err != nil {
+		return err
+	}
+	if opts.policy, err = loadSetupPolicy(policyFile); err != nil {
+		return err
+	}
+	if err := opts.policy.checkOptions(opts); err != nil {
+		return err
+	}
+	dataDir := spec.DataDir
+	if dataDir == "" {
+		dataDir = conf.DefaultDataStore
+	}
+	config := &conf.MenderConfigFromFile{
+		DeviceTypeFile: path.Join(dataDir, "device_type"),
+		SkipVerify:     spec.Security.SkipVerify,
+	}
+	// Also checks the configuration against the policy.
+	if err := opts.applyConfigOptions(config); err != nil {
+		return err
+	}
+
+	opts.configFormat = conf.FormatFromExtension(configPath)
+	if opts.configFormat == "" {
+		opts.configFormat = conf.FormatJSON
+	}
+	configData, err := conf.MarshalConfig(config, opts.configFormat)
+	if err != nil {
+		return err
+	}
+	updated := []string{}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{
+		{configPath, configData},
+		{config.DeviceTypeFile, opts.deviceTypeFileData()},
+	} {
+		current, err := ioutil.ReadFile(file.path)
+		if err != nil || !bytes.Equal(current, file.data) {
+			updated = append(updated, file.path)
+		}
+	}
+	if len(updated) == 0 {
+		fmt.Fprintln(w, "Configuration is up to date.")
+		return nil
+	}
+	if err := opts.writeConfigFiles(config); err != nil {
+		return err
+	}
+	for _, updatedPath := range updated {
+		fmt.Fprintf(w, "Updated %s\n", updatedPath)
+	}
+	return nil
+}
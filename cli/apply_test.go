@@ -0,0 +1,154 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// noPolicy is a policy file which does not exist.
+const noPolicy = "/nonexistent/setup-policy.json"
+
+func TestApplySpec(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	specPath := path.Join(tmpDir, "spec.yaml")
+	confPath := path.Join(tmpDir, "mender.conf")
+	require.NoError(t, ioutil.WriteFile(specPath, []byte(`
+device_type: raspberrypi4
+servers:
+  - https://primary.example.com
+  - https://secondary.example.com
+tenant_token: dummy-token
+data_dir: `+tmpDir+`
+polls:
+  update: 600
+security:
+  min_tls_version: "1.3"
+`), 0600))
+
+	var buf bytes.Buffer
+	require.NoError(t, applySpecFile(&buf, specPath, confPath, noPolicy))
+	assert.Equal(t, "Updated "+confPath+"\nUpdated "+
+		path.Join(tmpDir, "device_type")+"\n", buf.String())
+
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	require.Len(t, config.Servers, 2)
+	assert.Equal(t, "https://primary.example.com", config.Servers[0].ServerURL)
+	assert.Equal(t, "https://secondary.example.com", config.Servers[1].ServerURL)
+	assert.Equal(t, "dummy-token", config.TenantToken)
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, defaultInventoryPoll, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, defaultRetryPoll, config.RetryPollIntervalSeconds)
+	assert.Equal(t, "1.3", config.MinTLSVersion)
+	devType, err := ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=raspberrypi4\n", string(devType))
+
+	// Applying the same spec again changes nothing.
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(confPath, past, past))
+	before, err := os.Stat(confPath)
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, applySpecFile(&buf, specPath, confPath, noPolicy))
+	assert.Equal(t, "Configuration is up to date.\n", buf.String())
+	after, err := os.Stat(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, before.ModTime(), after.ModTime())
+
+	// A changed spec updates only the configuration, which is backed up.
+	previous, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(specPath, []byte(`
+device_type: raspberrypi4
+servers: [https://primary.example.com]
+data_dir: `+tmpDir+`
+`), 0600))
+	buf.Reset()
+	require.NoError(t, applySpecFile(&buf, specPath, confPath, noPolicy))
+	assert.Equal(t, "Updated "+confPath+"\n", buf.String())
+	backup, err := ioutil.ReadFile(confPath + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, previous, backup)
+}
+
+func TestApplySpecPolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	specPath := path.Join(tmpDir, "spec.yaml")
+	confPath := path.Join(tmpDir, "mender.conf")
+	policyPath := path.Join(tmpDir, "setup-policy.json")
+	require.NoError(t, ioutil.WriteFile(policyPath, []byte(
+		`{"ForbidSkipVerify": true, "RequireHTTPS": true}`), 0600))
+
+	for _, tc := range []struct {
+		spec string
+		err  string
+	}{
+		{"servers: [http://example.com]\n", "is not https"},
+		{"servers: [https://example.com, http://fallback.example.com]\n",
+			"http://fallback.example.com is not https"},
+		{"servers: [https://example.com]\nsecurity: {skip_verify: true}\n",
+			"Skipping the server certificate verification"},
+	} {
+		require.NoError(t, ioutil.WriteFile(specPath, []byte(
+			"device_type: dev\ndata_dir: "+tmpDir+"\n"+tc.spec), 0600))
+		err := applySpecFile(ioutil.Discard, specPath, confPath, policyPath)
+		assert.ErrorContains(t, err, tc.err, tc.spec)
+	}
+	_, err = os.Stat(confPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApplySpecInvalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	specPath := path.Join(tmpDir, "spec.yaml")
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	for _, tc := range []struct {
+		spec string
+		err  string
+	}{
+		{"servers: [https://example.com]\n", "Invalid device type"},
+		{"device_type: dev\n", "at least one server"},
+		{"device_type: dev\nservers: [example.com]\n", "Invalid server URL"},
+		{"device_type: dev\nservers: [https://example.com]\npolls: {update: 1}\n",
+			"the minimum is"},
+		{"device_type: dev\nserver_url: https://example.com\n",
+			"field server_url not found"},
+	} {
+		require.NoError(t, ioutil.WriteFile(specPath, []byte(tc.spec), 0600))
+		err := applySpecFile(ioutil.Discard, specPath, confPath, noPolicy)
+		assert.ErrorContains(t, err, tc.err, tc.spec)
+	}
+	_, err = os.Stat(confPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// artifactHeaderInfo holds the parts of an Artifact's "header-info" we care
+// about. Version 2 Artifacts list the compatible device types at the top
+// level, version 3 Artifacts list them under "artifact_depends".
+type artifactHeaderInfo struct {
+	DeviceTypesCompatible []string `json:"device_types_compatible"`
+	ArtifactDepends       struct {
+		DeviceType []string `json:"device_type"`
+	} `json:"artifact_depends"`
+}
+
+// readArtifactDeviceTypes returns the compatible device types declared in
+// the header of the Mender Artifact at artifactPath. Only gzip compressed
+// (or uncompressed) headers are supported.
+func readArtifactDeviceTypes(artifactPath string) ([]string, error) {
+	f, err := os.Open(artifactPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot open artifact %q", artifactPath)
+	}
+	defer f.Close()
+
+	outer := tar.NewReader(f)
+	for {
+		hdr, err := outer.Next()
+		if err == io.EOF {
+			return nil, errors.Errorf(
+				"No header found in artifact %q", artifactPath)
+		} else if err != nil {
+			return nil, errors.Wrapf(err,
+				"Error reading artifact %q", artifactPath)
+		}
+		if !strings.HasPrefix(hdr.Name, "header.tar") {
+			continue
+		}
+
+		var headerReader io.Reader
+		switch hdr.Name {
+		case "header.tar":
+			headerReader = outer
+		case "header.tar.gz":
+			gz, err := gzip.NewReader(outer)
+			if err != nil {
+				return nil, errors.Wrap(err,
+					"Error decompressing artifact header")
+			}
+			defer gz.Close()
+			headerReader = gz
+		default:
+			return nil, errors.Errorf(
+				"Unsupported artifact header compression: %s", hdr.Name)
+		}
+		return readHeaderInfoDeviceTypes(tar.NewReader(headerReader))
+	}
+}
+
+func readHeaderInfoDeviceTypes(header *tar.Reader) ([]string, error) {
+	for {
+		hdr, err := header.Next()
+		if err == io.EOF {
+			return nil, errors.New("No header-info found in artifact header")
+		} else if err != nil {
+			return nil, errors.Wrap(err, "Error reading artifact header")
+		}
+		if hdr.Name != "header-info" {
+			continue
+		}
+		var info artifactHeaderInfo
+		if err := json.NewDecoder(header).Decode(&info); err != nil {
+			return nil, errors.Wrap(err, "Error parsing artifact header-info")
+		}
+		deviceTypes := info.ArtifactDepends.DeviceType
+		if len(deviceTypes) == 0 {
+			deviceTypes = info.DeviceTypesCompatible
+		}
+		if len(deviceTypes) == 0 {
+			return nil, errors.New(
+				"Artifact does not declare any compatible device types")
+		}
+		return deviceTypes, nil
+	}
+}
+
+// deviceTypeFromArtifact picks the device type to use from the Artifact at
+// artifactPath. An Artifact compatible with a single device type yields that
+// type. If it is compatible with several, chosen must name one of them.
+func deviceTypeFromArtifact(artifactPath, chosen string) (string, error) {
+	deviceTypes, err := readArtifactDeviceTypes(artifactPath)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Artifact %s is compatible with device types: %v",
+		artifactPath, deviceTypes)
+	if chosen != "" {
+		for _, devType := range deviceTypes {
+			if devType == chosen {
+				return chosen, nil
+			}
+		}
+		return "", errors.Errorf(
+			"Device type %q is not among the types compatible with "+
+				"artifact %q: %s",
+			chosen, artifactPath, strings.Join(deviceTypes, ", "))
+	}
+	if len(deviceTypes) > 1 {
+		return "", errors.Errorf(
+			"Artifact %q is compatible with multiple device types (%s); "+
+				"select one with --device-type",
+			artifactPath, strings.Join(deviceTypes, ", "))
+	}
+	return deviceTypes[0], nil
+}
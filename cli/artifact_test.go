@@ -0,0 +1,97 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func addTarFile(t *testing.T, w *tar.Writer, name string, content []byte) {
+	require.NoError(t, w.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(content)),
+	}))
+	_, err := w.Write(content)
+	require.NoError(t, err)
+}
+
+// writeTestArtifact writes a minimal Artifact containing only what
+// readArtifactDeviceTypes looks at: the version file and a gzip'ed header
+// with a header-info.
+func writeTestArtifact(t *testing.T, dir, headerInfo string) string {
+	var header bytes.Buffer
+	gz := gzip.NewWriter(&header)
+	headerTar := tar.NewWriter(gz)
+	addTarFile(t, headerTar, "header-info", []byte(headerInfo))
+	require.NoError(t, headerTar.Close())
+	require.NoError(t, gz.Close())
+
+	artifactPath := path.Join(dir, "test.mender")
+	f, err := os.Create(artifactPath)
+	require.NoError(t, err)
+	defer f.Close()
+	outer := tar.NewWriter(f)
+	addTarFile(t, outer, "version", []byte(`{"format":"mender","version":3}`))
+	addTarFile(t, outer, "manifest", []byte{})
+	addTarFile(t, outer, "header.tar.gz", header.Bytes())
+	require.NoError(t, outer.Close())
+	return artifactPath
+}
+
+func TestDeviceTypeFromArtifact(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "artifact")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// Version 3 header with a single device type.
+	artifactPath := writeTestArtifact(t, tmpDir,
+		`{"artifact_depends":{"device_type":["raspberrypi4"]}}`)
+	devType, err := deviceTypeFromArtifact(artifactPath, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "raspberrypi4", devType)
+
+	flagSet := newFlagSet()
+	ctx := cli.NewContext(&cli.App{}, flagSet, nil)
+	opts := &setupOptionsType{deviceTypeArtifact: artifactPath}
+	require.NoError(t, opts.applyDeviceTypeFromArtifact(ctx))
+	assert.Equal(t, "raspberrypi4", opts.deviceType)
+	assert.Equal(t, "raspberrypi4", ctx.String("device-type"))
+
+	// Version 2 header with several device types.
+	artifactPath = writeTestArtifact(t, tmpDir,
+		`{"device_types_compatible":["beaglebone","qemux86-64"]}`)
+	_, err = deviceTypeFromArtifact(artifactPath, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "multiple device types")
+	devType, err = deviceTypeFromArtifact(artifactPath, "qemux86-64")
+	assert.NoError(t, err)
+	assert.Equal(t, "qemux86-64", devType)
+	_, err = deviceTypeFromArtifact(artifactPath, "raspberrypi4")
+	assert.Error(t, err)
+
+	// Not an artifact at all.
+	_, err = deviceTypeFromArtifact(path.Join(tmpDir, "missing.mender"), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// auditRecord is a line of the file given with --audit-log. It holds no
+// secrets, such as the tenant token.
+type auditRecord struct {
+	Timestamp  string `json:"timestamp"`
+	User       string `json:"user"`
+	ServerURL  string `json:"server_url"`
+	DeviceType string `json:"device_type"`
+	Demo       bool   `json:"demo"`
+	ConfigPath string `json:"config_path"`
+}
+
+// currentUser returns the name of the user running setup, or "" if unknown.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// appendAuditRecord appends a record of the completed setup to the audit
+// log as a JSON line, leaving a provisioning trail on the device.
+func (opts *setupOptionsType) appendAuditRecord() error {
+	data, err := json.Marshal(auditRecord{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		User:       currentUser(),
+		ServerURL:  opts.serverURL,
+		DeviceType: opts.deviceType,
+		Demo:       opts.demoServer && !opts.hostedMender,
+		ConfigPath: opts.configPath,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error encoding the audit record")
+	}
+	f, err := os.OpenFile(opts.auditLog,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot open the audit log %q", opts.auditLog)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Error writing the audit log %q", opts.auditLog)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupAuditLog(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	auditLog := path.Join(tmpDir, "audit.log")
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--tenant-token", "secret-token", "--demo-polling",
+		"--audit-log", auditLog}
+	before := time.Now().Add(-time.Second)
+	require.NoError(t, SetupCLI(args))
+	require.NoError(t, SetupCLI(args))
+
+	data, err := ioutil.ReadFile(auditLog)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret-token")
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "https://acme.mender.io", record["server_url"])
+	assert.Equal(t, "acme-pi", record["device_type"])
+	assert.Equal(t, false, record["demo"])
+	assert.Equal(t, confPath, record["config_path"])
+	assert.Equal(t, currentUser(), record["user"])
+	timestamp, err := time.Parse(time.RFC3339, record["timestamp"].(string))
+	require.NoError(t, err)
+	assert.False(t, timestamp.Before(before.Truncate(time.Second)))
+
+	// Nothing is appended on a dry run.
+	require.NoError(t, SetupCLI(append(args, "--dry-run")))
+	data, err = ioutil.ReadFile(auditLog)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// bootstrapArtifactName is the name of the bootstrap artifact in the data
+// store. The client does not read its path from the configuration file (see
+// conf.MenderConfig.BootstrapArtifactFile), so a bootstrap artifact in
+// another location is installed there instead.
+const bootstrapArtifactName = "bootstrap.mender"
+
+// checkBootstrapArtifact checks that the --bootstrap-artifact is a regular
+// file which can be read.
+func checkBootstrapArtifact(artifactPath string) error {
+	info, err := os.Stat(artifactPath)
+	if err != nil {
+		return errors.Wrap(err, "Invalid bootstrap artifact")
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("Invalid bootstrap artifact %q: not a regular "+
+			"file", artifactPath)
+	}
+	return nil
+}
+
+// installBootstrapArtifact copies the artifact at artifactPath to where the
+// client looks for the bootstrap artifact in dataStore.
+func installBootstrapArtifact(artifactPath, dataStore string) error {
+	dst := path.Join(dataStore, bootstrapArtifactName)
+	if artifactPath == dst {
+		return nil
+	}
+	src, err := os.Open(artifactPath)
+	if err != nil {
+		return errors.Wrap(err, "Error reading the bootstrap artifact")
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Error installing the bootstrap artifact")
+	}
+	if _, err = io.Copy(out, src); err != nil {
+		out.Close()
+		return errors.Wrap(err, "Error installing the bootstrap artifact")
+	}
+	if err = out.Close(); err != nil {
+		return errors.Wrap(err, "Error installing the bootstrap artifact")
+	}
+	log.Infof("Installed the bootstrap artifact %s as %s", artifactPath, dst)
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupBootstrapArtifact(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	dataDir := path.Join(tmpDir, "data")
+	artifact := path.Join(tmpDir, "custom", "acme-bootstrap.mender")
+	require.NoError(t, os.MkdirAll(path.Dir(artifact), 0755))
+	require.NoError(t, ioutil.WriteFile(artifact, []byte("artifact"), 0644))
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", path.Join(tmpDir, "mender.conf"), "--data", dataDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling", "--bootstrap-artifact"}
+	require.NoError(t, SetupCLI(append(args, artifact)))
+	installed, err := ioutil.ReadFile(path.Join(dataDir, bootstrapArtifactName))
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(installed))
+
+	// Only existing files are accepted
+	assert.Error(t, SetupCLI(append(args, path.Dir(artifact))))
+	assert.Error(t, SetupCLI(append(args, path.Join(tmpDir, "missing.mender"))))
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCertExpiryWarnDays = 30
+
+func (opts *setupOptionsType) certExpiryWarnDuration() time.Duration {
+	days := opts.certExpiryWarnDays
+	if days == 0 {
+		days = defaultCertExpiryWarnDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// expiringCertificates returns the certificates in the PEM data which are
+// no longer valid at the given time.
+func expiringCertificates(data []byte, at time.Time) []*x509.Certificate {
+	var expiring []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if at.After(cert.NotAfter) {
+			expiring = append(expiring, cert)
+		}
+	}
+	return expiring
+}
+
+// checkServerCertExpiry warns about server certificates which have expired
+// or expire within --cert-expiry-warn-days, since a device provisioned with
+// them would soon fail to connect.
+func (opts *setupOptionsType) checkServerCertExpiry() {
+	if opts.serverCert == "" || opts.usesDemoCert() {
+		return
+	}
+	data, err := ioutil.ReadFile(opts.serverCert)
+	if err != nil {
+		log.Debugf("Not checking the expiry of %s: %v", opts.serverCert, err)
+		return
+	}
+	now := time.Now()
+	threshold := opts.certExpiryWarnDuration()
+	for _, cert := range expiringCertificates(data, now.Add(threshold)) {
+		if now.After(cert.NotAfter) {
+			opts.warnings.warnf("The certificate %q in the server "+
+				"certificate %s expired on %s; provision the device with "+
+				"a valid certificate", cert.Subject.CommonName,
+				opts.serverCert, cert.NotAfter.UTC().Format("2006-01-02"))
+			continue
+		}
+		opts.warnings.warnf("The certificate %q in the server certificate "+
+			"%s expires on %s, within %d days; provision the device with a "+
+			"certificate valid for longer", cert.Subject.CommonName,
+			opts.serverCert, cert.NotAfter.UTC().Format("2006-01-02"),
+			int(threshold.Hours()/24))
+	}
+}
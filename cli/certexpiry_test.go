@@ -0,0 +1,103 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertExpiringAt returns a PEM encoded self-signed certificate valid
+// until notAfter.
+func newTestCertExpiringAt(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "acme.mender.io"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSetupServerCertExpiry(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	tmpDir := path.Dir(runOptions.setupOptions.configPath)
+	defer os.RemoveAll(tmpDir)
+	opts := &runOptions.setupOptions
+
+	notAfter := time.Now().Add(10 * 24 * time.Hour)
+	certPath := path.Join(tmpDir, "server.crt")
+	require.NoError(t, ioutil.WriteFile(certPath,
+		newTestCertExpiringAt(t, notAfter), 0644))
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "https://acme.mender.io")
+	opts.serverURL = "https://acme.mender.io"
+	ctx.Set("server-cert", certPath)
+	opts.serverCert = certPath
+
+	// Expiring within the default 30 days...
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	require.NoError(t, doSetup(ctx, config, opts))
+	assert.Contains(t, buf.String(), "\\\"acme.mender.io\\\" in the server "+
+		"certificate "+certPath+" expires on "+
+		notAfter.UTC().Format("2006-01-02")+", within 30 days")
+
+	// ...but not within a lower threshold.
+	buf.Reset()
+	opts.certExpiryWarnDays = 7
+	require.NoError(t, doSetup(ctx, config, opts))
+	assert.NotContains(t, buf.String(), "expires on")
+
+	// An expired certificate is not said to expire.
+	expiredAt := time.Now().Add(-2 * 24 * time.Hour)
+	require.NoError(t, ioutil.WriteFile(certPath,
+		newTestCertExpiringAt(t, expiredAt), 0644))
+	buf.Reset()
+	require.NoError(t, doSetup(ctx, config, opts))
+	assert.Contains(t, buf.String(), "certificate "+certPath+" expired on "+
+		expiredAt.UTC().Format("2006-01-02")+";")
+	assert.NotContains(t, buf.String(), "expires on")
+
+	opts.certExpiryWarnDays = -1
+	assert.Error(t, opts.validateFlags())
+}
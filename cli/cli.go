@@ -20,7 +20,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -48,14 +50,29 @@ const (
 )
 
 type runOptionsType struct {
-	config         string
-	fallbackConfig string
-	dataStore      string
+	config           string
+	fallbackConfig   string
+	dataStore        string
+	allowUnknownArgs bool
+	noLock           bool
+	requirePersist   bool
+	canonicalize     bool
+	configure        string // only the settings of this area
+	printCommand     bool
+	printConfigPath  bool
+	timings          bool
+	timingsFormat    string
+	warningsSummary  bool
+	policyFile       string
 	conf.HttpConfig
 	setupOptions setupOptionsType // Options for setup subcommand
 	logOptions   logOptionsType   // Options for logging
 }
 
+const warnSkipVerify = "WARNING: --skip-verify turns off the verification " +
+	"of the server certificate, so the device will trust any server. " +
+	"Do not use it on a device in production."
+
 func ShowVersion() string {
 	return fmt.Sprintf("%s\truntime: %s",
 		setup_conf.VersionString(), runtime.Version())
@@ -63,6 +80,8 @@ func ShowVersion() string {
 
 func SetupCLI(args []string) error {
 	runOptions := &runOptionsType{}
+	runOptions.setupOptions.invPollInterval = defaultInventoryPoll
+	runOptions.setupOptions.serverURL = defaultServerURL
 
 	app := &cli.App{
 		Description: appDescription,
@@ -71,6 +90,14 @@ func SetupCLI(args []string) error {
 		ArgsUsage:   "[options]",
 		Action:      runOptions.setupCLIHandler,
 		Version:     ShowVersion(),
+		Commands: []*cli.Command{
+			listServersCommand(),
+			validateCommand(),
+			redactCommand(),
+			pathsCommand(),
+			applyCommand(),
+			convertCommand(),
+		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "config",
@@ -79,6 +106,23 @@ func SetupCLI(args []string) error {
 				Value:       conf.DefaultConfFile,
 				Usage:       "`PATH` to configuration file.",
 			},
+			&cli.StringFlag{
+				Name:        "config-format",
+				Destination: &runOptions.setupOptions.configFormat,
+				Usage: "`FORMAT` of the configuration file: json or yaml " +
+					"(default: from the file extension, else json).",
+			},
+			&cli.BoolFlag{
+				Name:        "fix-extension",
+				Destination: &runOptions.setupOptions.fixExtension,
+				Usage:       "Correct the configuration file extension to match --config-format.",
+			},
+			&cli.StringFlag{
+				Name:        "prefix",
+				Destination: &runOptions.setupOptions.prefix,
+				Usage: "Install `PREFIX` of the Mender client: the default " +
+					"configuration file and data directories are resolved under it.",
+			},
 			&cli.StringFlag{
 				Name:    "data",
 				Aliases: []string{"d"},
@@ -90,22 +134,161 @@ func SetupCLI(args []string) error {
 				Destination: &runOptions.setupOptions.deviceType,
 				Usage:       "Name of the device `type`.",
 			},
+			&cli.StringFlag{
+				Name:        "device-type-from-artifact",
+				Destination: &runOptions.setupOptions.deviceTypeArtifact,
+				Usage: "Read the device type from the Mender Artifact at `PATH`. " +
+					"Use --device-type to pick one if it lists several.",
+			},
+			&cli.BoolFlag{
+				Name:        "normalize-device-type",
+				Destination: &runOptions.setupOptions.normalizeDevType,
+				Usage: "Lowercase and trim the device type, which artifacts " +
+					"are matched with case sensitively.",
+			},
+			&cli.BoolFlag{
+				Name:        "device-type-from-hostname-sanitized",
+				Destination: &runOptions.setupOptions.sanitizeHostname,
+				Usage: "When defaulting the device type to the host name, " +
+					"replace the characters not valid in a device type by '-'.",
+			},
 			&cli.StringFlag{
 				Name:        "username",
 				Destination: &runOptions.setupOptions.username,
-				Usage:       "User `E-Mail` at hosted.mender.io.",
+				Usage: "User `E-Mail` at hosted.mender.io. Defaults to $" +
+					envUsername + ".",
 			},
 			&cli.StringFlag{
 				Name:        "password",
 				Destination: &runOptions.setupOptions.password,
-				Usage:       "User `PASSWORD` at hosted.mender.io.",
+				Usage: "User `PASSWORD` at hosted.mender.io. Defaults to $" +
+					envPassword + ", which keeps it out of the command line.",
 			},
 			&cli.StringFlag{
-				Name:        "server-url",
-				Aliases:     []string{"url"},
-				Destination: &runOptions.setupOptions.serverURL,
-				Usage:       "`URL` to Mender server.",
-				Value:       "https://docker.mender.io",
+				Name:        "hosted-access-token",
+				Destination: &runOptions.setupOptions.accessToken,
+				EnvVars:     []string{"MENDER_ACCESS_TOKEN"},
+				Usage: "Hosted Mender Personal Access `TOKEN`, used instead of " +
+					"E-Mail and password.",
+			},
+			&cli.BoolFlag{
+				Name:        "use-server-recommended-polls",
+				Destination: &runOptions.setupOptions.useServerPolls,
+				Usage: "After logging in to Hosted Mender, use the poll " +
+					"intervals recommended for the plan, unless given with " +
+					"--update-poll, --inventory-poll or --retry-poll.",
+			},
+			&cli.IntFlag{
+				Name:        "login-timeout",
+				Destination: &runOptions.setupOptions.loginTimeout,
+				Usage:       "Give up on the Hosted Mender login after `SECONDS`.",
+				Value:       defaultLoginTimeout,
+			},
+			&cli.GenericFlag{
+				Name:    "server-url",
+				Aliases: []string{"url"},
+				Value: &serverURLsFlag{
+					urls: &runOptions.setupOptions.serverURL,
+				},
+				Usage: "`URL` to Mender server. Giving it several times, or " +
+					"a comma separated list, adds fallback servers, tried " +
+					"in the given order.",
+			},
+			&cli.StringFlag{
+				Name:        "discovery-url",
+				Destination: &runOptions.setupOptions.discoveryURL,
+				Usage: "`URL` returning the server_url and ca_cert to use, as " +
+					"JSON, instead of --server-url and --server-cert.",
+			},
+			&cli.BoolFlag{
+				Name:        "force-server-cert",
+				Destination: &runOptions.setupOptions.forceServerCert,
+				Usage: "Replace an existing, different server certificate " +
+					"with the one from --discovery-url, keeping a backup.",
+			},
+			&cli.StringFlag{
+				Name:        "config-url",
+				Destination: &runOptions.setupOptions.configURL,
+				Usage: "`URL` of the configuration to use, in which " +
+					configURLDeviceType + " is replaced by the device type. " +
+					"Flags given are overlaid on it as with --merge.",
+			},
+			&cli.BoolFlag{
+				Name:        "sorted-keys",
+				Destination: &runOptions.setupOptions.sortedKeys,
+				Usage: "Write the configuration keys in alphabetical order, " +
+					"for stable diffs across versions.",
+			},
+			&cli.StringFlag{
+				Name:        "bootstrap-artifact",
+				Destination: &runOptions.setupOptions.bootstrapArtifact,
+				Usage: "`PATH` to an artifact to install as the bootstrap " +
+					"artifact. The client only looks for it as " +
+					bootstrapArtifactName + " in the data store, so it is " +
+					"copied there.",
+			},
+			&cli.BoolFlag{
+				Name:        "stdout",
+				Destination: &runOptions.setupOptions.toStdout,
+				Usage: "Print the configuration instead of writing it, e.g. " +
+					"to copy it to a device later, without writing the " +
+					"device type file or modifying the hosts file.",
+			},
+			&cli.BoolFlag{
+				Name:        "write-checksum",
+				Destination: &runOptions.setupOptions.writeChecksum,
+				Usage: "Also write the SHA-256 digest of the configuration " +
+					"file to <config>.sha256.",
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Destination: &runOptions.setupOptions.dryRun,
+				Usage: "Log the files which would be written, and their " +
+					"contents, instead of writing them.",
+			},
+			&cli.StringFlag{
+				Name:        "write-env-file",
+				Destination: &runOptions.setupOptions.envFile,
+				Usage: "Also write the chosen settings as KEY=value lines to " +
+					"`PATH`, for init systems reading an EnvironmentFile.",
+			},
+			&cli.BoolFlag{
+				Name:        "merge",
+				Destination: &runOptions.setupOptions.merge,
+				Usage: "Keep the servers, server certificate and poll intervals " +
+					"of the existing configuration file unless given with " +
+					"flags, instead of asking for them.",
+			},
+			&cli.BoolFlag{
+				Name:        "explain",
+				Destination: &runOptions.setupOptions.explain,
+				Usage: "Explain what each answer means for the device. " +
+					"Ignored with --quiet.",
+			},
+			&cli.BoolFlag{
+				Name:        "preserve-acls",
+				Destination: &runOptions.setupOptions.preserveACLs,
+				Usage: "Keep the POSIX ACLs, SELinux context and other " +
+					"extended attributes of the configuration and " +
+					"device_type files when rewriting them. Linux only.",
+			},
+			&cli.BoolFlag{
+				Name:        "no-backup",
+				Destination: &runOptions.setupOptions.noBackup,
+				Usage: "Do not copy an existing configuration file to " +
+					"<config>.bak before overwriting it.",
+			},
+			&cli.BoolFlag{
+				Name:        "check-rate-limits",
+				Destination: &runOptions.setupOptions.checkRateLimits,
+				Usage: "Warn when the poll intervals would make enough " +
+					"requests per device to typically be rate limited.",
+			},
+			&cli.BoolFlag{
+				Name:        "allow-insecure-http",
+				Destination: &runOptions.setupOptions.allowInsecureHTTP,
+				Usage: "Allow a plain http server URL, e.g. a local test server, " +
+					"without certificate or demo server setup.",
 			},
 			&cli.StringFlag{
 				Name:        "server-ip",
@@ -118,22 +301,135 @@ func SetupCLI(args []string) error {
 				Destination: &runOptions.setupOptions.serverCert,
 				Usage:       "`PATH` to trusted server certificates",
 			},
+			&cli.IntFlag{
+				Name:        "cert-expiry-warn-days",
+				Destination: &runOptions.setupOptions.certExpiryWarnDays,
+				Usage: "Warn when the server certificate expires within " +
+					"`DAYS` (default: 30).",
+			},
+			&cli.BoolFlag{
+				Name:        "server-cert-overrides-demo",
+				Destination: &runOptions.setupOptions.certOverridesDemo,
+				Usage: "With --demo-server, write the certificate given with " +
+					"--server-cert rather than the demo certificate.",
+			},
+			&cli.BoolFlag{
+				Name:        "skip-verify",
+				Destination: &runOptions.HttpConfig.NoVerify,
+				Usage: "Write SkipVerify, so that the client does not verify " +
+					"the server certificate. Insecure; only for a quick test.",
+			},
+			&cli.StringFlag{
+				Name:        "client-ca-bundle",
+				Destination: &runOptions.setupOptions.clientCABundle,
+				Usage: "`PATH` to PEM CA certificates trusted for setup's own " +
+					"requests, e.g. logging in, instead of the system trust. " +
+					"Unlike --server-cert, this is not written for the device.",
+			},
+			&cli.StringSliceFlag{
+				Name: "client-ca-dir",
+				Usage: "`DIR` of PEM CA certificate files trusted for setup's " +
+					"own requests, together with --client-ca-bundle; repeat " +
+					"for several directories.",
+			},
+			&cli.StringFlag{
+				Name:        "proxy",
+				Destination: &runOptions.setupOptions.proxy,
+				Usage: "Proxy `URL` for setup's own requests, e.g. logging in, " +
+					"overriding $HTTP_PROXY, $HTTPS_PROXY and $NO_PROXY.",
+			},
+			&cli.StringFlag{
+				Name:        "min-tls-version",
+				Destination: &runOptions.setupOptions.minTLSVersion,
+				Usage: "Minimum TLS `VERSION` (1.0, 1.1, 1.2 or 1.3) to record for " +
+					"the client and to use for setup's own connections.",
+			},
+			&cli.BoolFlag{
+				Name:        "check-reachability",
+				Destination: &runOptions.setupOptions.checkReachability,
+				Usage: "Check that the demo server can be reached and presents a " +
+					"certificate valid for the server URL.",
+			},
+			&cli.IntFlag{
+				Name:        "check-timeout",
+				Destination: &runOptions.setupOptions.checkTimeout,
+				Usage: "Give up on the reachability check and the discovery " +
+					"request after `SECONDS`.",
+				Value: defaultCheckTimeout,
+			},
 			&cli.StringFlag{
 				Name:        "tenant-token",
 				Destination: &runOptions.setupOptions.tenantToken,
 				Usage:       "Hosted Mender tenant `token`",
 			},
-			&cli.IntFlag{
-				Name:        "inventory-poll",
-				Destination: &runOptions.setupOptions.invPollInterval,
-				Usage:       "Inventory poll interval in `sec`onds.",
-				Value:       defaultInventoryPoll,
+			&cli.StringFlag{
+				Name:        "tenant-token-file",
+				Destination: &runOptions.setupOptions.tenantTokenFile,
+				Usage: "`PATH` to a file with the Hosted Mender tenant token, " +
+					"which keeps it out of the shell history.",
+			},
+			&cli.GenericFlag{
+				Name: "inventory-poll",
+				Value: &inventoryPollFlag{
+					seconds: &runOptions.setupOptions.invPollInterval,
+				},
+				Usage: "Inventory poll interval in `sec`onds, or \"" +
+					pollDisabledKeyword + "\", which writes " +
+					strconv.Itoa(inventoryPollDisabled) + " (about 68 " +
+					"years) so that inventory is only sent when the " +
+					"client starts.",
 			},
 			&cli.IntFlag{
 				Name:        "retry-poll",
 				Destination: &runOptions.setupOptions.retryPollInterval,
-				Usage:       "Retry poll interval in `sec`onds.",
-				Value:       defaultRetryPoll,
+				Usage: "Retry poll interval in `sec`onds; kept with " +
+					"--demo-polling too.",
+				Value: defaultRetryPoll,
+			},
+			&cli.IntFlag{
+				Name:        "retry-poll-count",
+				Destination: &runOptions.setupOptions.retryPollCount,
+				Usage: "Maximum `COUNT` of retry polls before the client " +
+					"gives up on a request, or 0 for unlimited.",
+			},
+			&cli.StringFlag{
+				Name:        "client-cert",
+				Destination: &runOptions.setupOptions.clientCert,
+				Usage: "`PATH` to the client certificate, for a server " +
+					"requiring mutual TLS.",
+			},
+			&cli.StringFlag{
+				Name:        "client-key",
+				Destination: &runOptions.setupOptions.clientKey,
+				Usage: "`PATH` to the private key of the client certificate, " +
+					"or its identifier within --ssl-engine.",
+			},
+			&cli.StringFlag{
+				Name:        "ssl-engine",
+				Destination: &runOptions.setupOptions.sslEngine,
+				Usage:       "OpenSSL `ENGINE` holding the client private key.",
+			},
+			&cli.StringFlag{
+				Name:        "artifact-verify-key",
+				Destination: &runOptions.setupOptions.artifactVerifyKey,
+				Usage:       "`PATH` to the key verifying signed Artifacts.",
+			},
+			&cli.StringSliceFlag{
+				Name: "artifact-verify-keys",
+				Usage: "`PATH` to a key verifying signed Artifacts; repeat for " +
+					"several keys. Cannot be combined with --artifact-verify-key.",
+			},
+			&cli.BoolFlag{
+				Name:        "disable-keepalive",
+				Destination: &runOptions.setupOptions.disableKeepAlive,
+				Usage: "Disable persistent (keep-alive) connections to the " +
+					"server, e.g. on unreliable cellular links.",
+			},
+			&cli.IntFlag{
+				Name:        "idle-conn-timeout",
+				Destination: &runOptions.setupOptions.idleConnTimeout,
+				Usage: "Close connections to the server after `SEC`onds " +
+					"idle (default: the client default).",
 			},
 			&cli.IntFlag{
 				Name:        "update-poll",
@@ -162,10 +458,230 @@ func SetupCLI(args []string) error {
 				Destination: &runOptions.setupOptions.demoIntervals,
 				Usage:       "Use demo polling intervals.",
 			},
+			&cli.IntFlag{
+				Name:        "poll-jitter",
+				Destination: &runOptions.setupOptions.pollJitter,
+				Usage: "Spread the update and inventory polls randomly by this " +
+					"`PERCENT`age. Requires --experimental.",
+			},
+			&cli.BoolFlag{
+				Name:        "experimental",
+				Destination: &runOptions.setupOptions.experimental,
+				Usage:       "Allow settings which the client does not support yet.",
+			},
+			&cli.StringFlag{
+				Name:        "profile",
+				Destination: &runOptions.setupOptions.profile,
+				Usage: "Tuning `PROFILE` for the poll intervals and connection " +
+					"handling: lowbandwidth, balanced or aggressive. Explicit " +
+					"poll interval flags take precedence.",
+			},
+			&cli.StringFlag{
+				Name:        "server-config-style",
+				Destination: &runOptions.setupOptions.serverConfigStyle,
+				Usage: "Write the server certificate and tenant token at the " +
+					"top level (`STYLE` top-level, for clients of all ages) " +
+					"or inside each Servers entry (per-server).",
+				Value: serverConfigTopLevel,
+			},
+			&cli.BoolFlag{
+				Name:        "keep-server-url",
+				Destination: &runOptions.setupOptions.keepServerURL,
+				Usage: "Also write the primary server to the legacy ServerURL, " +
+					"for clients reading it as a fallback.",
+			},
+			&cli.StringFlag{
+				Name:        "demo-cert",
+				Destination: &runOptions.setupOptions.demoCert,
+				Usage: "`PATH` to the demo server certificate, if not " +
+					"installed with the Mender client.",
+			},
+			&cli.StringFlag{
+				Name:        "trust-style",
+				Destination: &runOptions.setupOptions.trustStyle,
+				Usage: "`STYLE` of the system certificate store the demo " +
+					"certificate is installed in: debian or redhat. " +
+					"Detected from /etc/os-release if not given.",
+			},
+			&cli.BoolFlag{
+				Name:        "skip-ca-refresh",
+				Destination: &runOptions.setupOptions.skipCARefresh,
+				Usage: "Do not run update-ca-certificates after installing " +
+					"the demo certificate, e.g. where it does not exist.",
+			},
+			&cli.StringFlag{
+				Name:        "hosts-file",
+				Destination: &runOptions.setupOptions.hostsFile,
+				Usage: "Hosts `FILE` to read and update with the demo server " +
+					"route, e.g. ${ROOTFS}/etc/hosts for an image build.",
+				Value: DefaultHostsFile,
+			},
+			&cli.StringFlag{
+				Name:        "device-type-line-ending",
+				Destination: &runOptions.setupOptions.deviceTypeLineEnding,
+				Usage: "`ENDING` of the line in the device_type file: lf, " +
+					"crlf or none.",
+				Value: lineEndingLF,
+			},
+			&cli.StringFlag{
+				Name:        "hosts-update-mode",
+				Destination: &runOptions.setupOptions.hostsUpdateMode,
+				Usage: "`MODE` for adding the demo server route to the hosts file: " +
+					"append (always), replace (an existing entry) or skip (if present).",
+				Value: hostsUpdateSkip,
+			},
+			&cli.BoolFlag{
+				Name:        "force-demo",
+				Destination: &runOptions.setupOptions.forceDemo,
+				Usage: "Allow the demo server or demo poll intervals on a " +
+					"device marked as a production one.",
+			},
+			&cli.BoolFlag{
+				Name:        "no-hosts",
+				Destination: &runOptions.setupOptions.noHosts,
+				Usage: "Do not add the demo server route to the hosts file, " +
+					"e.g. in non-interactive runs on a shared system.",
+			},
+			&cli.BoolFlag{
+				Name:        "no-demo-intervals-clamp",
+				Destination: &runOptions.setupOptions.noDemoClamp,
+				Usage: "With demo polling, keep explicitly given poll intervals " +
+					"exactly, even below the usual minimum.",
+			},
+			&cli.BoolFlag{
+				Name:        "non-interactive",
+				Aliases:     []string{"batch"},
+				Destination: &runOptions.setupOptions.nonInteractive,
+				Usage: "Fail instead of prompting for a value which was not " +
+					"given with a flag, and use the defaults of " +
+					"confirmation questions.",
+			},
+			&cli.StringFlag{
+				Name:        "audit-log",
+				Destination: &runOptions.setupOptions.auditLog,
+				Usage: "Append a JSON line recording the completed setup " +
+					"to `FILE`, without secrets.",
+			},
+			&cli.StringFlag{
+				Name:        "input-json",
+				Destination: &runOptions.setupOptions.inputJSON,
+				Usage: "Set up without prompting from the JSON object in " +
+					"`FILE`, or from stdin with -, describing all the " +
+					"settings. See the README for the fields.",
+			},
+			&cli.StringFlag{
+				Name:        "answers-json",
+				Destination: &runOptions.setupOptions.answersJSON,
+				Usage: "Read the answers from a JSON object in `FILE`, or " +
+					"from stdin with -, mapping flag names to their values. " +
+					"Flags given on the command line win.",
+			},
+			&cli.BoolFlag{
+				Name:        "assume-yes",
+				Destination: &runOptions.setupOptions.assumeYes,
+				Usage: "Answer yes to confirmation questions and skip " +
+					"the review of the settings.",
+			},
 			&cli.BoolFlag{
 				Name:  "quiet",
 				Usage: "Suppress informative prompts.",
 			},
+			&cli.BoolFlag{
+				Name:        "strict",
+				Destination: &runOptions.setupOptions.strict,
+				Usage: "Treat inconsistent settings, and failing to install " +
+					"the demo certificate in the local trust, as errors " +
+					"rather than warnings.",
+			},
+			&cli.BoolFlag{
+				Name:        "select-features",
+				Destination: &runOptions.setupOptions.selectFeatures,
+				Usage: "Start by choosing optional areas to configure " +
+					"interactively: mutual TLS, connectivity, state script " +
+					"timeouts and Artifact verification keys.",
+			},
+			&cli.BoolFlag{
+				Name:        "verify-with-client",
+				Destination: &runOptions.setupOptions.verifyClient,
+				Usage: "Check that the mender client, if installed, accepts " +
+					"the written configuration.",
+			},
+			&cli.StringFlag{
+				Name:        "policy-file",
+				Destination: &runOptions.policyFile,
+				Usage: "`PATH` to the system-wide setup policy, which sets " +
+					"defaults and forbids some choices, if it exists.",
+				Value: DefaultPolicyFile,
+			},
+			&cli.BoolFlag{
+				Name:        "warnings-summary",
+				Destination: &runOptions.warningsSummary,
+				Usage: "Print all warnings together at the end of the run, " +
+					"rather than as they occur.",
+			},
+			&cli.BoolFlag{
+				Name:        "allow-unknown-args",
+				Destination: &runOptions.allowUnknownArgs,
+				Usage:       "Warn about, rather than reject, stray positional arguments.",
+			},
+			&cli.BoolFlag{
+				Name:        "canonicalize",
+				Destination: &runOptions.canonicalize,
+				Usage: "Rewrite the existing configuration file in canonical form " +
+					"without changing any settings.",
+			},
+			&cli.StringFlag{
+				Name:        "configure",
+				Destination: &runOptions.configure,
+				Usage: "Only change the settings of `AREA` in the existing " +
+					"configuration file, without running the wizard. The " +
+					"only area is logging: --daemon-log-level and " +
+					"--update-log-path.",
+			},
+			&cli.StringFlag{
+				Name:        "daemon-log-level",
+				Destination: &runOptions.setupOptions.daemonLogLevel,
+				Usage:       "`LEVEL` the client daemon logs at, e.g. info or debug.",
+			},
+			&cli.StringFlag{
+				Name:        "update-log-path",
+				Destination: &runOptions.setupOptions.updateLogPath,
+				Usage:       "`PATH` of the deployment log file.",
+			},
+			&cli.BoolFlag{
+				Name:        "require-persistent",
+				Destination: &runOptions.requirePersist,
+				Usage: "Fail if the configuration or data store directory is on " +
+					"a volatile file system (tmpfs or ramfs).",
+			},
+			&cli.BoolFlag{
+				Name:        "no-lock",
+				Destination: &runOptions.noLock,
+				Usage:       "Do not guard against concurrent setup runs with a lock file.",
+			},
+			&cli.BoolFlag{
+				Name:        "print-equivalent-command",
+				Destination: &runOptions.printCommand,
+				Usage: "Print the mender-setup command which reproduces this " +
+					"setup non-interactively.",
+			},
+			&cli.BoolFlag{
+				Name:        "print-config-path",
+				Destination: &runOptions.printConfigPath,
+				Usage: "Print the absolute path of the written configuration; " +
+					"with --quiet, nothing else is printed.",
+			},
+			&cli.BoolFlag{
+				Name:        "timings",
+				Destination: &runOptions.timings,
+				Usage:       "Report how long each phase of the setup took.",
+			},
+			&cli.StringFlag{
+				Name:        "timings-format",
+				Destination: &runOptions.timingsFormat,
+				Usage:       "`FORMAT` of the timing report: text or json.",
+				Value:       "text",
+			},
 			&cli.StringFlag{
 				Name:        "log-level",
 				Aliases:     []string{"l"},
@@ -189,12 +705,19 @@ func (runOptions *runOptionsType) commonCLIHandler(
 	log.Debug("commonCLIHandler config file: ", runOptions.config)
 
 	// Handle config flags
+	stopTiming := runOptions.setupOptions.timings.start(phaseConfigLoad)
 	config, err := conf.LoadConfig(
 		runOptions.config, runOptions.fallbackConfig)
+	stopTiming()
 	if err != nil {
 		return nil, err
 	}
 
+	if runOptions.setupOptions.fetchedConfig != nil {
+		// The configuration from --config-url replaces the local one.
+		config.MenderConfigFromFile = *runOptions.setupOptions.fetchedConfig
+	}
+
 	// Make sure that paths that are not configurable via the config file is consistent with
 	// --data flag
 	config.ArtifactScriptsPath = path.Join(runOptions.dataStore, "scripts")
@@ -213,6 +736,9 @@ func (runOptions *runOptionsType) commonCLIHandler(
 	}
 
 	if runOptions.HttpConfig.NoVerify {
+		// Printed even with --quiet, as the device is left open to
+		// anyone impersonating the server.
+		fmt.Fprintln(os.Stderr, warnSkipVerify)
 		config.SkipVerify = true
 	}
 
@@ -231,31 +757,147 @@ func (runOptions *runOptionsType) handleCLIOptions(ctx *cli.Context) error {
 	// Check that user has permission to directories so that
 	// the user doesn't have to perform the setup before raising
 	// an error.
-	log.Debug("handleCLIOptions config file: ", runOptions.config)
-	if err = checkWritePermissions(path.Dir(runOptions.config)); err != nil {
-		return err
+	// With --stdout, nothing is written on this device.
+	toStdout := runOptions.setupOptions.toStdout
+	dryRun := runOptions.setupOptions.dryRun
+	if !toStdout && !dryRun {
+		if err = runOptions.checkOutputDirs(); err != nil {
+			return err
+		}
 	}
-	log.Debug("handleCLIOptions dataStore file: ", runOptions.dataStore)
-	if err = checkWritePermissions(runOptions.dataStore); err != nil {
-		return err
+	// Hold the lock until all files have been written.
+	if !runOptions.noLock && !toStdout && !dryRun {
+		lock, err := acquireSetupLock(runOptions.dataStore)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
 	}
 	// Run cli setup prompts.
 	if err := doSetup(ctx, &config.MenderConfigFromFile,
 		&runOptions.setupOptions); err != nil {
 		return err
 	}
-	if !ctx.Bool("quiet") {
+	if dryRun {
+		log.Info("Dry run: nothing was written")
+	} else if runOptions.setupOptions.bootstrapArtifact != "" {
+		if err := installBootstrapArtifact(
+			runOptions.setupOptions.bootstrapArtifact,
+			runOptions.dataStore); err != nil {
+			return err
+		}
+	}
+	if runOptions.setupOptions.verifyClient && !dryRun {
+		if err := verifyWithClient(
+			runOptions.setupOptions.configPath); err != nil {
+			return err
+		}
+	}
+	if runOptions.setupOptions.auditLog != "" && !toStdout {
+		if dryRun {
+			log.Infof("Dry run: would append to the audit log %s",
+				runOptions.setupOptions.auditLog)
+		} else if err := runOptions.setupOptions.appendAuditRecord(); err != nil {
+			return err
+		}
+	}
+	if !ctx.Bool("quiet") && !toStdout && !dryRun {
 		fmt.Println(promptDone)
+		fmt.Println(runOptions.setupOptions.summary(
+			&config.MenderConfigFromFile))
+	}
+	// With --stdout, stdout carries only the configuration.
+	out := os.Stdout
+	if toStdout {
+		out = os.Stderr
+	}
+	if runOptions.printCommand {
+		fmt.Fprintln(out, runOptions.setupOptions.equivalentCommand(
+			runOptions.HttpConfig.NoVerify))
+	}
+	if runOptions.printConfigPath {
+		configPath, err := filepath.Abs(runOptions.setupOptions.configPath)
+		if err != nil {
+			return errors.Wrap(err, "Cannot resolve the configuration path")
+		}
+		fmt.Println(configPath)
+	}
+	if err = runOptions.setupOptions.timings.writeReport(
+		out, runOptions.timingsFormat == "json",
+		runOptions.setupOptions.warnings.collected()); err != nil {
+		return err
 	}
 
 	return err
 }
 
+// checkOutputDirs checks that the configuration and data store directories
+// can be written to, and, with --require-persistent, persist.
+func (runOptions *runOptionsType) checkOutputDirs() error {
+	log.Debug("handleCLIOptions config file: ", runOptions.config)
+	if err := checkWritePermissions(path.Dir(runOptions.config)); err != nil {
+		return err
+	}
+	log.Debug("handleCLIOptions dataStore file: ", runOptions.dataStore)
+	if err := checkWritePermissions(runOptions.dataStore); err != nil {
+		return err
+	}
+	if runOptions.requirePersist {
+		return checkPersistentStorage(path.Dir(runOptions.config),
+			runOptions.dataStore)
+	}
+	return nil
+}
+
+// checkPositionalArgs rejects stray positional arguments, unless
+// --allow-unknown-args is given, in which case they are only warned about.
+func (runOptions *runOptionsType) checkPositionalArgs(ctx *cli.Context) error {
+	if ctx.Args().Len() == 0 {
+		return nil
+	}
+	if runOptions.allowUnknownArgs {
+		runOptions.setupOptions.warnings.warnf("Ignoring unrecognized arguments: %s",
+			strings.Join(ctx.Args().Slice(), " "))
+		return nil
+	}
+	return errors.Errorf(
+		errMsgAmbiguousArgumentsGivenF,
+		ctx.Args().First())
+}
+
+// applyPrefix resolves the default paths under --prefix, for the
+// configuration file and data directory which are not given with flags.
+func (runOptions *runOptionsType) applyPrefix(ctx *cli.Context) {
+	if runOptions.setupOptions.prefix == "" {
+		return
+	}
+	conf.SetPrefix(runOptions.setupOptions.prefix)
+	if !ctx.IsSet("config") {
+		runOptions.setupOptions.configPath = conf.DefaultConfFile
+		runOptions.config = conf.DefaultConfFile
+	}
+	if !ctx.IsSet("data") {
+		_ = ctx.Set("data", conf.DefaultDataStore)
+	}
+}
+
 func (runOptions *runOptionsType) setupCLIHandler(ctx *cli.Context) error {
-	if ctx.Args().Len() > 0 {
-		return errors.Errorf(
-			errMsgAmbiguousArgumentsGivenF,
-			ctx.Args().First())
+	if runOptions.warningsSummary {
+		runOptions.setupOptions.warnings = newWarningSink()
+		defer runOptions.setupOptions.warnings.writeSummary(os.Stderr)
+	}
+	if err := runOptions.checkPositionalArgs(ctx); err != nil {
+		return err
+	}
+	if err := runOptions.setupOptions.applyInputJSON(ctx); err != nil {
+		return err
+	}
+	if err := runOptions.setupOptions.applyAnswersJSON(ctx); err != nil {
+		return err
+	}
+	runOptions.applyPrefix(ctx)
+	if err := runOptions.setupOptions.readTenantTokenFile(ctx); err != nil {
+		return err
 	}
 
 	if ctx.Bool("quiet") {
@@ -268,10 +910,59 @@ func (runOptions *runOptionsType) setupCLIHandler(ctx *cli.Context) error {
 				"Failed to parse set log level '%s'.", ctx.String("log-level"))
 		}
 	}
+	if runOptions.setupOptions.dryRun && !ctx.Bool("quiet") &&
+		!ctx.IsSet("log-level") && !log.IsLevelEnabled(log.InfoLevel) {
+		// The report of --dry-run is logged at info level.
+		log.SetLevel(log.InfoLevel)
+	}
+
+	policy, err := loadSetupPolicy(runOptions.policyFile)
+	if err != nil {
+		return err
+	}
+	policy.applyDefaults(ctx)
+	runOptions.setupOptions.policy = policy
 
+	runOptions.setupOptions.resolveConfigDir()
+	// Read before the discovery request, which trusts these too.
+	runOptions.setupOptions.clientCADirs = ctx.StringSlice("client-ca-dir")
+	if err := runOptions.setupOptions.validateRequestFlags(); err != nil {
+		return err
+	}
+	if err := runOptions.setupOptions.applyDiscovery(ctx); err != nil {
+		return err
+	}
 	if err := runOptions.setupOptions.handleImplicitFlags(ctx); err != nil {
 		return err
 	}
+	if err := runOptions.setupOptions.validateFlags(); err != nil {
+		return err
+	}
+	if err := runOptions.setupOptions.applyDeviceTypeFromArtifact(ctx); err != nil {
+		return err
+	}
+	if err := runOptions.setupOptions.applyConfigURL(ctx); err != nil {
+		return err
+	}
+
+	if runOptions.printConfigPath && runOptions.setupOptions.toStdout {
+		// No configuration file is written.
+		return errors.Errorf(errMsgConflictingArgumentsF,
+			"stdout", "print-config-path")
+	}
+	if runOptions.timings {
+		switch runOptions.timingsFormat {
+		case "text", "json":
+		default:
+			return errors.Errorf("Invalid timings format %q: "+
+				"must be one of text or json", runOptions.timingsFormat)
+		}
+		runOptions.setupOptions.timings = newPhaseTimings()
+	}
+
+	if err := runOptions.setupOptions.checkConfigFormat(); err != nil {
+		return err
+	}
 
 	// Handle overlapping global flags
 	if ctx.IsSet("config") && !ctx.IsSet("config") {
@@ -280,6 +971,14 @@ func (runOptions *runOptionsType) setupCLIHandler(ctx *cli.Context) error {
 		runOptions.config = runOptions.setupOptions.configPath
 	}
 	runOptions.dataStore = ctx.String("data")
+	if runOptions.canonicalize {
+		return canonicalizeConfigFile(runOptions.config,
+			runOptions.setupOptions.configFormat,
+			runOptions.setupOptions.dryRun)
+	}
+	if runOptions.configure != "" {
+		return runOptions.setupOptions.configureArea(runOptions.configure)
+	}
 	if runOptions.HttpConfig.ServerCert != "" &&
 		runOptions.setupOptions.serverCert == "" {
 		runOptions.setupOptions.serverCert = runOptions.HttpConfig.ServerCert
@@ -289,6 +988,68 @@ func (runOptions *runOptionsType) setupCLIHandler(ctx *cli.Context) error {
 	return runOptions.handleCLIOptions(ctx)
 }
 
+// canonicalizeConfigFile rewrites an existing configuration file in
+// canonical form, without prompting for or changing any settings. With
+// dryRun, the result is logged instead.
+func canonicalizeConfigFile(configPath, format string, dryRun bool) error {
+	config, err := loadExistingConfig(configPath)
+	if err != nil {
+		return err
+	}
+	conf.CanonicalizeConfig(&config.MenderConfigFromFile)
+	if dryRun {
+		data, err := conf.MarshalConfig(&config.MenderConfigFromFile, format)
+		if err != nil {
+			return err
+		}
+		logDryRunWrite(configPath, data)
+		return nil
+	}
+	return conf.SaveConfigFileFormat(
+		&config.MenderConfigFromFile, configPath, format)
+}
+
+// configureArea changes only the settings of the given area in the existing
+// configuration file, without running the wizard.
+func (opts *setupOptionsType) configureArea(area string) error {
+	if area != configureLogging {
+		return errors.Errorf("Invalid configuration area %q: must be %s",
+			area, configureLogging)
+	}
+	fields := map[string]interface{}{}
+	if opts.daemonLogLevel != "" {
+		fields["DaemonLogLevel"] = opts.daemonLogLevel
+	}
+	if opts.updateLogPath != "" {
+		fields["UpdateLogPath"] = opts.updateLogPath
+	}
+	if len(fields) == 0 {
+		return errors.New("--configure logging requires " +
+			"--daemon-log-level or --update-log-path")
+	}
+	if opts.dryRun {
+		data, err := conf.MarshalConfigFileFields(
+			opts.configPath, opts.configFormat, fields)
+		if err != nil {
+			return err
+		}
+		logDryRunWrite(opts.configPath, data)
+		return nil
+	}
+	return conf.SetConfigFileFields(
+		opts.configPath, opts.configFormat, fields)
+}
+
+// minHelpWidth is the narrowest terminal the help is wrapped for; below it,
+// e.g. on a terminal misreporting its size, the help is written as is.
+const minHelpWidth = 40
+
+// needed so that we can override it when testing
+var getTerminalWidth = func() (int, error) {
+	width, _, err := terminal.GetSize(int(os.Stdout.Fd()))
+	return width, err
+}
+
 func upgradeHelpPrinter(defaultPrinter func(w io.Writer, templ string, data interface{})) func(
 	w io.Writer, templ string, data interface{}) {
 	// Applies the ordinary help printer with column post processing
@@ -307,8 +1068,8 @@ func upgradeHelpPrinter(defaultPrinter func(w io.Writer, templ string, data inte
 		// defaultPrinter parses the text-template and outputs to buffer
 		var buf bytes.Buffer
 		defaultPrinter(&buf, templ, data)
-		terminalWidth, _, err := terminal.GetSize(int(os.Stdout.Fd()))
-		if err != nil || terminalWidth <= 0 {
+		terminalWidth, err := getTerminalWidth()
+		if err != nil || terminalWidth < minHelpWidth {
 			// Just write help as is.
 			stdout.Write(buf.Bytes())
 			return
@@ -378,3 +1139,34 @@ func checkWritePermissions(dir string) error {
 	os.Remove(f.Name())
 	return nil
 }
+
+// serverURLsFlag is the value of --server-url, which may be given several
+// times to add fallback servers. The servers are kept as a comma separated
+// list, in the given order.
+type serverURLsFlag struct {
+	urls *string
+	set  bool
+}
+
+func (f *serverURLsFlag) Set(value string) error {
+	if f.set && value == *f.urls {
+		// The cli package copies the value to the aliases of the flag by
+		// setting it again.
+		return nil
+	}
+	if f.set {
+		*f.urls += "," + value
+	} else {
+		// Replaces the default
+		*f.urls = value
+		f.set = true
+	}
+	return nil
+}
+
+func (f *serverURLsFlag) String() string {
+	if f.urls == nil {
+		return ""
+	}
+	return *f.urls
+}
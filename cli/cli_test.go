@@ -0,0 +1,697 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mendersoftware/mender-setup/conf"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCheckPositionalArgs(t *testing.T) {
+	flagSet := newFlagSet()
+	err := flagSet.Parse([]string{"--quiet", "stray"})
+	assert.NoError(t, err)
+	ctx := cli.NewContext(&cli.App{}, flagSet, nil)
+
+	runOptions := &runOptionsType{}
+	err = runOptions.checkPositionalArgs(ctx)
+	assert.EqualError(t, err,
+		"Ambiguous arguments given - unrecognized argument: stray")
+
+	runOptions.allowUnknownArgs = true
+	err = runOptions.checkPositionalArgs(ctx)
+	assert.NoError(t, err)
+}
+
+func TestCanonicalizeConfigFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "canonicalize")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	err = ioutil.WriteFile(confPath, []byte(`{
+  "ArtifactVerifyKey": "/etc/mender/artifact-verify-key.pem",
+  "ServerURL": "https://legacy.mender.io/",
+  "Servers": [{"ServerURL": "https://fallback.mender.io/"}],
+  "UpdatePollIntervalSeconds": 1800,
+  "TenantToken": "token"
+}`), 0600)
+	require.NoError(t, err)
+
+	err = canonicalizeConfigFile(confPath, conf.FormatJSON, false)
+	require.NoError(t, err)
+
+	var genericMap map[string]interface{}
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &genericMap))
+	assert.NotContains(t, genericMap, "ArtifactVerifyKey")
+	assert.NotContains(t, genericMap, "ServerURL")
+	assert.Equal(t,
+		[]interface{}{"/etc/mender/artifact-verify-key.pem"},
+		genericMap["ArtifactVerifyKeys"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"ServerURL": "https://legacy.mender.io"},
+		map[string]interface{}{"ServerURL": "https://fallback.mender.io"},
+	}, genericMap["Servers"])
+	assert.Equal(t, float64(1800), genericMap["UpdatePollIntervalSeconds"])
+	assert.Equal(t, "token", genericMap["TenantToken"])
+
+	// Nothing to canonicalize.
+	err = canonicalizeConfigFile(path.Join(tmpDir, "missing.conf"),
+		conf.FormatJSON, false)
+	assert.Error(t, err)
+}
+
+func TestCanonicalizeAndConfigureDryRun(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	const existing = `{"ServerURL": "https://legacy.mender.io/"}`
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(existing), 0600))
+
+	for _, args := range [][]string{
+		{"--canonicalize"},
+		{"--configure", "logging", "--daemon-log-level", "debug"},
+	} {
+		logBuf.Reset()
+		require.NoError(t, SetupCLI(append([]string{"mender-setup",
+			"--dry-run", "--log-level", "info",
+			"--config", confPath, "--data", tmpDir}, args...)), args)
+		data, err := ioutil.ReadFile(confPath)
+		require.NoError(t, err)
+		assert.Equal(t, existing, string(data), args)
+		assert.Contains(t, logBuf.String(), "Dry run: would write "+confPath,
+			args)
+	}
+}
+
+func TestConfigureLogging(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	const existing = `{
+  "ServerURL": "https://legacy.mender.io/",
+  "UpdatePollIntervalSeconds": 1800,
+  "TenantToken": "token",
+  "DaemonLogLevel": "info",
+  "SomeFutureSetting": {"Enabled": true}
+}`
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(existing), 0600))
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--configure", "logging", "--daemon-log-level", "debug",
+		"--update-log-path", "/var/log/mender/deploy.log"})
+	require.NoError(t, err)
+
+	var before, after map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(existing), &before))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &after))
+	before["DaemonLogLevel"] = "debug"
+	before["UpdateLogPath"] = "/var/log/mender/deploy.log"
+	assert.Equal(t, before, after)
+
+	for _, args := range [][]string{
+		{"--configure", "logging"},
+		{"--configure", "network", "--daemon-log-level", "debug"},
+		{"--configure", "logging", "--daemon-log-level", "chatty"},
+	} {
+		err = SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--config", confPath, "--data", tmpDir}, args...))
+		assert.Error(t, err, args)
+	}
+	// The file must exist.
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", path.Join(tmpDir, "missing.conf"), "--data", tmpDir,
+		"--configure", "logging", "--daemon-log-level", "debug"})
+	assert.Error(t, err)
+}
+
+func TestSetupRetryPollCount(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--update-poll", "1800", "--inventory-poll", "28800",
+		"--retry-poll", "300"}
+	require.NoError(t, SetupCLI(append(args, "--retry-poll-count", "3")))
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, config.RetryPollCount)
+
+	// Not given, the count is left as it is.
+	require.NoError(t, SetupCLI(args))
+	config, err = conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, config.RetryPollCount)
+
+	err = SetupCLI(append(args, "--retry-poll-count", "-1"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid retry poll count -1")
+
+	// Given, the count is not asked for along with the retry poll.
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdin = stdinR
+	stdinW.WriteString("\n") // Retry poll interval (default)
+	stdinW.Close()
+	require.NoError(t, SetupCLI(append(args[:len(args)-2],
+		"--retry-poll-count", "5")))
+	config, err = conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, defaultRetryPoll, config.RetryPollIntervalSeconds)
+	assert.Equal(t, 5, config.RetryPollCount)
+}
+
+func TestSetupConnectivityFlags(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling"}
+	require.NoError(t, SetupCLI(args))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "DisableKeepAlive")
+	assert.NotContains(t, string(data), "IdleConnTimeoutSeconds")
+
+	require.NoError(t, SetupCLI(append(args,
+		"--disable-keepalive", "--idle-conn-timeout", "30")))
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, conf.Connectivity{
+		DisableKeepAlive:       true,
+		IdleConnTimeoutSeconds: 30,
+	}, config.Connectivity)
+
+	err = SetupCLI(append(args, "--idle-conn-timeout", "-1"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid idle connection timeout -1")
+}
+
+func TestSetupArtifactVerifyKeys(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	keyA := path.Join(tmpDir, "a.pem")
+	keyB := path.Join(tmpDir, "b.pem")
+	for _, key := range []string{keyA, keyB} {
+		require.NoError(t, ioutil.WriteFile(key, []byte("key"), 0644))
+	}
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling"}
+	require.NoError(t, SetupCLI(append(args, "--artifact-verify-key", keyA)))
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	// Loading moves the single key to the list.
+	assert.Equal(t, []string{keyA}, config.ArtifactVerifyKeys)
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"ArtifactVerifyKey": "`+keyA+`"`)
+
+	require.NoError(t, SetupCLI(append(args,
+		"--artifact-verify-keys", keyA, "--artifact-verify-keys", keyB)))
+	data, err = ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"ArtifactVerifyKey"`)
+	config, err = conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{keyA, keyB}, config.ArtifactVerifyKeys)
+
+	err = SetupCLI(append(args, "--artifact-verify-key", keyA,
+		"--artifact-verify-keys", keyB))
+	assert.ErrorContains(t, err, "Conflicting arguments")
+	err = SetupCLI(append(args,
+		"--artifact-verify-keys", path.Join(tmpDir, "missing.pem")))
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestSetupKeepServerURL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io,https://backup.acme.io",
+		"--server-cert", "", "--demo-polling"}
+	readConfig := func() conf.MenderConfigFromFile {
+		var config conf.MenderConfigFromFile
+		data, err := ioutil.ReadFile(confPath)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &config))
+		return config
+	}
+
+	require.NoError(t, SetupCLI(append(args, "--keep-server-url")))
+	config := readConfig()
+	assert.Equal(t, "https://acme.mender.io", config.ServerURL)
+	require.Len(t, config.Servers, 2)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+
+	// By default it is cleared.
+	require.NoError(t, SetupCLI(args))
+	config = readConfig()
+	assert.Equal(t, "", config.ServerURL)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+}
+
+func TestSetupDeviceTypeLineEnding(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", path.Join(tmpDir, "mender.conf"), "--data", tmpDir,
+		"--device-type", "acme-pi", "--server-url", "https://acme.mender.io",
+		"--server-cert", "", "--demo-polling"}
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{nil, "device_type=acme-pi\n"},
+		{[]string{"--device-type-line-ending", "lf"}, "device_type=acme-pi\n"},
+		{[]string{"--device-type-line-ending", "crlf"}, "device_type=acme-pi\r\n"},
+		{[]string{"--device-type-line-ending", "none"}, "device_type=acme-pi"},
+	} {
+		require.NoError(t, SetupCLI(append(args, tc.args...)), tc.args)
+		data, err := ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, string(data), tc.args)
+	}
+
+	err = SetupCLI(append(args, "--device-type-line-ending", "cr"))
+	assert.ErrorContains(t, err, "Invalid device type line ending")
+}
+
+func TestSetupKeepsUnknownConfigFields(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(`{
+		"Servers": [{"ServerURL": "https://old.mender.io"}],
+		"FutureOption": {"Enabled": true}
+	}`), 0600))
+
+	require.NoError(t, SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi", "--server-url", "https://acme.mender.io",
+		"--server-cert", "", "--demo-polling"}))
+
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	var config map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.JSONEq(t, `{"Enabled": true}`, string(config["FutureOption"]))
+	assert.JSONEq(t, `[{"ServerURL": "https://acme.mender.io"}]`,
+		string(config["Servers"]))
+}
+
+func TestSetupSkipVerify(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	setupCapturingStderr := func(args ...string) string {
+		stderr := os.Stderr
+		stderrR, stderrW, err := os.Pipe()
+		require.NoError(t, err)
+		os.Stderr = stderrW
+		err = SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--config", confPath, "--data", tmpDir,
+			"--device-type", "acme-pi", "--server-url", "https://acme.mender.io",
+			"--server-cert", "", "--demo-polling"}, args...))
+		os.Stderr = stderr
+		stderrW.Close()
+		require.NoError(t, err)
+		output, err := ioutil.ReadAll(stderrR)
+		require.NoError(t, err)
+		return string(output)
+	}
+
+	output := setupCapturingStderr("--skip-verify")
+	assert.Contains(t, output, warnSkipVerify)
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.True(t, config.SkipVerify)
+
+	require.NoError(t, os.Remove(confPath))
+	output = setupCapturingStderr()
+	assert.NotContains(t, output, "--skip-verify")
+	config, err = conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.False(t, config.SkipVerify)
+}
+
+func TestSetupDemoRetryPoll(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	args := []string{"mender-setup", "--quiet", "--non-interactive",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi", "--server-url", "https://acme.mender.io",
+		"--server-cert", "", "--demo-polling"}
+
+	// A given retry interval is kept with the demo intervals...
+	require.NoError(t, SetupCLI(append(args, "--retry-poll", "45")))
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, demoUpdatePoll, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, demoInventoryPoll, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, 45, config.RetryPollIntervalSeconds)
+
+	// ...while the demo one is used without it...
+	require.NoError(t, SetupCLI(args))
+	config, err = conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, demoRetryPoll, config.RetryPollIntervalSeconds)
+
+	// ...and it must be at least the minimum.
+	err = SetupCLI(append(args, "--retry-poll", "3"))
+	assert.ErrorContains(t, err, "retry-poll")
+}
+
+func TestSetupPrefix(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer conf.ReloadPaths()
+	defer log.SetLevel(log.GetLevel())
+	for _, dir := range []string{"etc/mender", "var/lib/mender"} {
+		require.NoError(t, os.MkdirAll(path.Join(tmpDir, dir), 0755))
+	}
+
+	err = SetupCLI([]string{"mender-setup", "--quiet", "--prefix", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--update-poll", "1800", "--inventory-poll", "28800",
+		"--retry-poll", "300"})
+	require.NoError(t, err)
+
+	config, err := conf.LoadConfig(
+		path.Join(tmpDir, "etc", "mender", "mender.conf"), "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+	devType, err := ioutil.ReadFile(
+		path.Join(tmpDir, "var", "lib", "mender", "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=acme-pi\n", string(devType))
+}
+
+func TestPrintConfigPathQuiet(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	// --quiet lowers the log level for the whole process.
+	defer log.SetLevel(log.GetLevel())
+
+	stdout := os.Stdout
+	stdoutR, stdoutW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdout = stdout }()
+	os.Stdout = stdoutW
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling", "--print-config-path"})
+	os.Stdout = stdout
+	stdoutW.Close()
+	require.NoError(t, err)
+
+	output, err := ioutil.ReadAll(stdoutR)
+	require.NoError(t, err)
+	assert.Equal(t, confPath+"\n", string(output))
+	_, err = os.Stat(confPath)
+	assert.NoError(t, err)
+}
+
+func TestSetupSummary(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--non-interactive",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--update-poll", "600", "--inventory-poll", "disabled",
+		"--retry-poll", "60"}
+	setupOutput := func(args []string) string {
+		stdout := os.Stdout
+		stdoutR, stdoutW, err := os.Pipe()
+		require.NoError(t, err)
+		defer func() { os.Stdout = stdout }()
+		os.Stdout = stdoutW
+		err = SetupCLI(args)
+		os.Stdout = stdout
+		stdoutW.Close()
+		require.NoError(t, err)
+		output, err := ioutil.ReadAll(stdoutR)
+		require.NoError(t, err)
+		return string(output)
+	}
+
+	output := setupOutput(args)
+	assert.Contains(t, output, promptDone+"\n"+
+		"Configuration: "+confPath+"\n"+
+		"Server URL: https://acme.mender.io\n"+
+		"Device type: acme-pi\n"+
+		"Poll intervals: update 600s, inventory disabled, retry 60s\n")
+
+	// --quiet suppresses the summary.
+	output = setupOutput(append(args, "--quiet"))
+	assert.NotContains(t, output, "Configuration:")
+}
+
+func TestSetupConfigDirectory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", tmpDir, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling"})
+	require.NoError(t, err)
+
+	config, err := conf.LoadConfig(path.Join(tmpDir, "mender.conf"), "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+}
+
+func TestSetupInventoryPollDisabled(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--update-poll", "1800", "--retry-poll", "300",
+		"--inventory-poll", "disabled"})
+	require.NoError(t, err)
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2147483647, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, 1800, config.UpdatePollIntervalSeconds)
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--inventory-poll", "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestSetupStdout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confDir := path.Join(tmpDir, "etc")
+	dataDir := path.Join(tmpDir, "data")
+
+	stdout := os.Stdout
+	stdoutR, stdoutW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdout = stdout }()
+	os.Stdout = stdoutW
+
+	// The other reports go to stderr, keeping stdout for the configuration.
+	err = SetupCLI([]string{"mender-setup", "--stdout",
+		"--config", path.Join(confDir, "mender.conf"), "--data", dataDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling", "--print-equivalent-command", "--timings"})
+	os.Stdout = stdout
+	stdoutW.Close()
+	require.NoError(t, err)
+
+	output, err := ioutil.ReadAll(stdoutR)
+	require.NoError(t, err)
+	var config conf.MenderConfigFromFile
+	require.NoError(t, json.Unmarshal(output, &config))
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+	assert.Equal(t, path.Join(dataDir, "device_type"), config.DeviceTypeFile)
+
+	// Nothing is written on this device
+	_, err = os.Stat(confDir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dataDir)
+	assert.True(t, os.IsNotExist(err))
+
+	err = SetupCLI([]string{"mender-setup", "--stdout", "--write-checksum",
+		"--config", path.Join(confDir, "mender.conf"), "--data", dataDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling"})
+	assert.Error(t, err)
+
+	err = SetupCLI([]string{"mender-setup", "--stdout", "--print-config-path",
+		"--config", path.Join(confDir, "mender.conf"), "--data", dataDir,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--demo-polling"})
+	assert.ErrorContains(t, err, "print-config-path")
+}
+
+func TestSetupRepeatedServerURL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi", "--server-cert", "", "--demo-polling",
+		"--server-url", "https://acme.mender.io",
+		"--server-url", "https://backup.acme.io,https://backup2.acme.io",
+		"--server-url", "https://backup3.acme.io"})
+	require.NoError(t, err)
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	var urls []string
+	for _, server := range config.Servers {
+		urls = append(urls, server.ServerURL)
+	}
+	assert.Equal(t, []string{"https://acme.mender.io",
+		"https://backup.acme.io", "https://backup2.acme.io",
+		"https://backup3.acme.io"}, urls)
+
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi", "--server-cert", "", "--demo-polling",
+		"--server-url", "https://acme.mender.io",
+		"--server-url", "backup.acme.io"})
+	assert.Error(t, err)
+}
+
+func TestUpgradeHelpPrinterWidth(t *testing.T) {
+	oldGetTerminalWidth := getTerminalWidth
+	defer func() {
+		getTerminalWidth = oldGetTerminalWidth
+	}()
+	help := "USAGE:\n" +
+		"   --server-url URL  Set the URL of the Mender server to connect " +
+		"to, or several separated by commas.\n"
+	printer := upgradeHelpPrinter(func(w io.Writer, templ string,
+		data interface{}) {
+		io.WriteString(w, templ)
+	})
+
+	// A terminal too narrow to wrap for gets the help as is.
+	for _, width := range []int{1, minHelpWidth - 1} {
+		getTerminalWidth = func() (int, error) { return width, nil }
+		var buf bytes.Buffer
+		printer(&buf, help, nil)
+		assert.Equal(t, help, buf.String(), width)
+	}
+
+	getTerminalWidth = func() (int, error) { return 60, nil }
+	var buf bytes.Buffer
+	printer(&buf, help, nil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Greater(t, len(lines), 2)
+	for _, line := range lines {
+		assert.LessOrEqual(t, len(line), 60, line)
+	}
+	assert.Equal(t, strings.Fields(help), strings.Fields(buf.String()))
+}
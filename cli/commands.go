@@ -0,0 +1,447 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// loadExistingConfig loads the configuration file at configPath, which,
+// unlike for conf.LoadConfig, must exist. A configPath of "-" reads the
+// configuration as JSON from stdin.
+func loadExistingConfig(configPath string) (*conf.MenderConfig, error) {
+	if configPath == "-" {
+		return conf.LoadConfigReader(os.Stdin, conf.FormatJSON)
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, errors.Wrapf(err,
+			"Cannot read configuration file %q", configPath)
+	}
+	return conf.LoadConfig(configPath, "")
+}
+
+func configFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "config",
+		Aliases: []string{"c"},
+		Value:   conf.DefaultConfFile,
+		Usage:   "`PATH` to configuration file.",
+	}
+}
+
+func validateCommand() *cli.Command {
+	flag := configFlag()
+	flag.Usage = "`PATH` to configuration file, or - to read it from stdin."
+	return &cli.Command{
+		Name:  "validate",
+		Usage: "Check an existing configuration file for problems.",
+		Flags: []cli.Flag{
+			flag,
+			&cli.StringSliceFlag{
+				Name: "field",
+				Usage: "Only check the configuration `FIELD`, e.g. " +
+					"UpdatePollIntervalSeconds; repeat for several fields.",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return validateConfigFile(ctx.App.Writer, ctx.String("config"),
+				ctx.StringSlice("field")...)
+		},
+	}
+}
+
+const problemArtifactVerifyKeys = "Only one of ArtifactVerifyKey and " +
+	"ArtifactVerifyKeys can be set"
+
+// validateConfigFile loads the configuration at configPath and prints the
+// problems found in it, failing if there are any. With fields, only those
+// fields are checked.
+func validateConfigFile(w io.Writer, configPath string, fields ...string) error {
+	validators, err := configValidatorsFor(fields)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	config, err := loadExistingConfig(configPath)
+	if errors.Cause(err) == conf.ErrArtifactVerifyKeysConflict &&
+		validatesField(validators, "ArtifactVerifyKeys") {
+		// The configuration cannot be loaded, so this is the only
+		// problem which can be reported.
+		problems = []string{problemArtifactVerifyKeys}
+	} else if err != nil {
+		return err
+	} else {
+		for _, validator := range validators {
+			problems = append(problems,
+				validator.check(&config.MenderConfigFromFile)...)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "Configuration is valid.")
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem)
+	}
+	return errors.Errorf("Configuration has %d problem(s)", len(problems))
+}
+
+// configValidator checks the configuration fields it is named after.
+type configValidator struct {
+	fields []string
+	check  func(config *conf.MenderConfigFromFile) []string
+}
+
+// configValidators are the checks of validate, in the order their problems
+// are reported.
+var configValidators = []configValidator{
+	{[]string{"Servers", "ServerURL"}, validateServerURLs},
+	{[]string{"UpdatePollIntervalSeconds"},
+		validatePollInterval("UpdatePollIntervalSeconds",
+			func(c *conf.MenderConfigFromFile) int {
+				return c.UpdatePollIntervalSeconds
+			})},
+	{[]string{"InventoryPollIntervalSeconds"},
+		validatePollInterval("InventoryPollIntervalSeconds",
+			func(c *conf.MenderConfigFromFile) int {
+				return c.InventoryPollIntervalSeconds
+			})},
+	{[]string{"RetryPollIntervalSeconds"},
+		validatePollInterval("RetryPollIntervalSeconds",
+			func(c *conf.MenderConfigFromFile) int {
+				return c.RetryPollIntervalSeconds
+			})},
+	{[]string{"MinTLSVersion"}, validateMinTLSVersion},
+	{[]string{"ServerCertificate"}, validateServerCertificates},
+	{[]string{"ArtifactVerifyKey", "ArtifactVerifyKeys"},
+		validateArtifactVerifyKeys},
+}
+
+// configValidatorsFor returns the validators checking the given fields, or
+// all of them if there are none.
+func configValidatorsFor(fields []string) ([]configValidator, error) {
+	if len(fields) == 0 {
+		return configValidators, nil
+	}
+	var validators []configValidator
+	for _, validator := range configValidators {
+		for _, field := range fields {
+			if validator.validates(field) {
+				validators = append(validators, validator)
+				break
+			}
+		}
+	}
+	for _, field := range fields {
+		if !validatesField(validators, field) {
+			var known []string
+			for _, validator := range configValidators {
+				known = append(known, validator.fields...)
+			}
+			return nil, errors.Errorf("Cannot validate the field %q: "+
+				"must be one of %s", field, strings.Join(known, ", "))
+		}
+	}
+	return validators, nil
+}
+
+func (validator configValidator) validates(field string) bool {
+	for _, f := range validator.fields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func validatesField(validators []configValidator, field string) bool {
+	for _, validator := range validators {
+		if validator.validates(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateConfig returns the problems found in config: settings which
+// mender-setup would not have written, and which may stop the client from
+// connecting.
+func validateConfig(config *conf.MenderConfigFromFile) []string {
+	var problems []string
+	for _, validator := range configValidators {
+		problems = append(problems, validator.check(config)...)
+	}
+	return problems
+}
+
+func validateServerURLs(config *conf.MenderConfigFromFile) []string {
+	var problems []string
+	urls := []string{}
+	for _, server := range config.Servers {
+		urls = append(urls, server.ServerURL)
+	}
+	if config.ServerURL != "" {
+		urls = append(urls, config.ServerURL)
+	}
+	if len(urls) == 0 {
+		problems = append(problems, "No server is configured")
+	}
+	for _, serverURL := range urls {
+		if !isValidServerURL(serverURL) {
+			problems = append(problems, fmt.Sprintf(
+				"Invalid server URL %q", serverURL))
+		}
+	}
+	return problems
+}
+
+func validatePollInterval(name string,
+	interval func(*conf.MenderConfigFromFile) int,
+) func(*conf.MenderConfigFromFile) []string {
+	return func(config *conf.MenderConfigFromFile) []string {
+		seconds := interval(config)
+		if seconds != 0 && seconds < minimumPollInterval {
+			return []string{fmt.Sprintf("%s is %d; the minimum is %d seconds",
+				name, seconds, minimumPollInterval)}
+		}
+		return nil
+	}
+}
+
+func validateMinTLSVersion(config *conf.MenderConfigFromFile) []string {
+	if config.MinTLSVersion == "" {
+		return nil
+	}
+	if _, err := parseTLSVersion(config.MinTLSVersion); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+func validateServerCertificates(config *conf.MenderConfigFromFile) []string {
+	var problems []string
+	certs := []string{config.ServerCertificate}
+	for _, server := range config.Servers {
+		certs = append(certs, server.ServerCertificate)
+	}
+	for _, cert := range certs {
+		if cert == "" {
+			continue
+		}
+		if _, err := os.Stat(cert); err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"ServerCertificate %q cannot be read: %s", cert, err))
+		}
+	}
+	return problems
+}
+
+func validateArtifactVerifyKeys(config *conf.MenderConfigFromFile) []string {
+	if err := conf.CheckArtifactVerifyKeys(config.ArtifactVerifyKey,
+		config.ArtifactVerifyKeys); err != nil {
+		return []string{problemArtifactVerifyKeys}
+	}
+	return nil
+}
+
+const redacted = "<redacted>"
+
+func redactCommand() *cli.Command {
+	flag := configFlag()
+	flag.Usage = "`PATH` to configuration file, or - to read it from stdin."
+	return &cli.Command{
+		Name: "redact",
+		Usage: "Print a configuration file with secrets and key paths " +
+			"redacted, e.g. for a bug report.",
+		Flags: []cli.Flag{flag},
+		Action: func(ctx *cli.Context) error {
+			return printRedactedConfig(ctx.App.Writer, ctx.String("config"))
+		},
+	}
+}
+
+// printRedactedConfig prints the configuration at configPath as JSON, with
+// the tenant tokens, and the paths of certificates and keys, replaced.
+func printRedactedConfig(w io.Writer, configPath string) error {
+	config, err := loadExistingConfig(configPath)
+	if err != nil {
+		return err
+	}
+	redactConfig(&config.MenderConfigFromFile)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	// Keep the placeholder readable rather than \u003credacted\u003e.
+	enc.SetEscapeHTML(false)
+	return enc.Encode(&config.MenderConfigFromFile)
+}
+
+func redactConfig(config *conf.MenderConfigFromFile) {
+	redact := func(value *string) {
+		if *value != "" {
+			*value = redacted
+		}
+	}
+	redact(&config.TenantToken)
+	redact(&config.ServerCertificate)
+	redact(&config.HttpsClient.Certificate)
+	redact(&config.HttpsClient.Key)
+	redact(&config.ArtifactVerifyKey)
+	for i := range config.ArtifactVerifyKeys {
+		redact(&config.ArtifactVerifyKeys[i])
+	}
+	for i := range config.Servers {
+		redact(&config.Servers[i].TenantToken)
+		redact(&config.Servers[i].ServerCertificate)
+	}
+}
+
+func listServersCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "list-servers",
+		Usage: "Print the servers of an existing configuration file, in order.",
+		Flags: []cli.Flag{
+			configFlag(),
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the servers as JSON.",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return listServers(ctx.App.Writer,
+				ctx.String("config"), ctx.Bool("json"))
+		},
+	}
+}
+
+// listServers prints the Servers of the configuration in order, followed by
+// the legacy ServerURL if present.
+func listServers(w io.Writer, configPath string, asJSON bool) error {
+	config, err := loadExistingConfig(configPath)
+	if err != nil {
+		return err
+	}
+	servers := []string{}
+	for _, server := range config.Servers {
+		servers = append(servers, server.ServerURL)
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(struct {
+			Servers   []string
+			ServerURL string `json:",omitempty"`
+		}{
+			Servers:   servers,
+			ServerURL: config.ServerURL,
+		})
+	}
+	for _, server := range servers {
+		fmt.Fprintln(w, server)
+	}
+	if config.ServerURL != "" {
+		fmt.Fprintln(w, config.ServerURL)
+	}
+	return nil
+}
+
+func pathsCommand() *cli.Command {
+	return &cli.Command{
+		Name: "paths",
+		Usage: "Print the default paths, as resolved from the MENDER_CONF_DIR, " +
+			"MENDER_DATASTORE_DIR and MENDER_DATA_DIR environment variables.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the paths as JSON.",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return printPaths(ctx.App.Writer, ctx.Bool("json"))
+		},
+	}
+}
+
+// printPaths prints the default paths of conf, one per line or as JSON.
+func printPaths(w io.Writer, asJSON bool) error {
+	paths := conf.DefaultPaths()
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(paths)
+	}
+	fmt.Fprintf(w, "conf dir:           %s\n", paths.ConfDir)
+	fmt.Fprintf(w, "conf file:          %s\n", paths.ConfFile)
+	fmt.Fprintf(w, "data store:         %s\n", paths.DataStore)
+	fmt.Fprintf(w, "data dir:           %s\n", paths.DataDir)
+	fmt.Fprintf(w, "artifact scripts:   %s\n", paths.ArtScriptsPath)
+	fmt.Fprintf(w, "rootfs scripts:     %s\n", paths.RootfsScriptsPath)
+	fmt.Fprintf(w, "modules:            %s\n", paths.ModulesPath)
+	fmt.Fprintf(w, "modules work:       %s\n", paths.ModulesWorkPath)
+	fmt.Fprintf(w, "bootstrap artifact: %s\n", paths.BootstrapArtifactFile)
+	return nil
+}
+
+func convertCommand() *cli.Command {
+	return &cli.Command{
+		Name: "convert",
+		Usage: "Convert a configuration file to another format, e.g. YAML " +
+			"authored for the device to the JSON the client reads, without " +
+			"changing any values.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "in",
+				Usage:    "`PATH` to the configuration file to convert.",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "out",
+				Usage:    "`PATH` to write the converted configuration file to.",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name: "format",
+				Usage: "`FORMAT` to convert to: json or yaml. Defaults to " +
+					"the format implied by the extension of --out, or json.",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return convertConfigFile(ctx.String("in"), ctx.String("out"),
+				ctx.String("format"))
+		},
+	}
+}
+
+// convertConfigFile writes the configuration at inPath to outPath in format,
+// or else in the format implied by the extension of outPath.
+func convertConfigFile(inPath, outPath, format string) error {
+	if format == "" {
+		format = conf.FormatFromExtension(outPath)
+	}
+	if format == "" {
+		format = conf.FormatJSON
+	}
+	if err := conf.ValidateFormat(format); err != nil {
+		return err
+	}
+	return conf.ConvertConfigFile(inPath, outPath, format)
+}
@@ -0,0 +1,270 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// writeTestConfig writes content as mender.conf in a new temporary
+// directory, which the caller must remove.
+func writeTestConfig(t *testing.T, content string) string {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	confPath := path.Join(tmpDir, "mender.conf")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(content), 0600))
+	return confPath
+}
+
+func TestListServers(t *testing.T) {
+	confPath := writeTestConfig(t, `{
+  "Servers": [
+    {"ServerURL": "https://primary.mender.io"},
+    {"ServerURL": "https://secondary.mender.io"}
+  ]
+}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	var buf bytes.Buffer
+	require.NoError(t, listServers(&buf, confPath, false))
+	assert.Equal(t,
+		"https://primary.mender.io\nhttps://secondary.mender.io\n",
+		buf.String())
+
+	buf.Reset()
+	require.NoError(t, listServers(&buf, confPath, true))
+	assert.JSONEq(t, `{"Servers": [
+		"https://primary.mender.io",
+		"https://secondary.mender.io"
+	]}`, buf.String())
+
+	err := listServers(&buf, path.Join(path.Dir(confPath), "missing"), false)
+	assert.Error(t, err)
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	confPath := writeTestConfig(t, `{
+  "Servers": [{"ServerURL": "https://acme.mender.io"}],
+  "UpdatePollIntervalSeconds": 1800
+}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	var buf bytes.Buffer
+	require.NoError(t, validateConfigFile(&buf, confPath))
+	assert.Equal(t, "Configuration is valid.\n", buf.String())
+
+	confPath = writeTestConfig(t, `{
+  "Servers": [{"ServerURL": "acme.mender.io"}],
+  "RetryPollIntervalSeconds": 1,
+  "MinTLSVersion": "1.7"
+}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	buf.Reset()
+	err := validateConfigFile(&buf, confPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 problem(s)")
+	assert.Contains(t, buf.String(), `Invalid server URL "acme.mender.io"`)
+	assert.Contains(t, buf.String(), "RetryPollIntervalSeconds is 1")
+	assert.Contains(t, buf.String(), "1.7")
+}
+
+func TestValidateConfigSemantics(t *testing.T) {
+	confPath := writeTestConfig(t, `{
+  "Servers": [{"ServerURL": "https://acme.mender.io"}]
+}`)
+	defer os.RemoveAll(path.Dir(confPath))
+	cert := path.Join(path.Dir(confPath), "server.crt")
+	require.NoError(t, ioutil.WriteFile(cert, []byte("cert"), 0644))
+
+	var buf bytes.Buffer
+	config := &conf.MenderConfigFromFile{
+		Servers:           []conf.MenderServer{{ServerURL: "https://acme.mender.io"}},
+		ServerCertificate: cert,
+	}
+	assert.Empty(t, validateConfig(config))
+
+	config.ServerCertificate = path.Join(path.Dir(confPath), "missing.crt")
+	config.ArtifactVerifyKey = "/etc/mender/key.pem"
+	config.ArtifactVerifyKeys = []string{"/etc/mender/other.pem"}
+	problems := validateConfig(config)
+	require.Len(t, problems, 2)
+	assert.Contains(t, problems[0], `ServerCertificate "`+
+		config.ServerCertificate+`" cannot be read`)
+	assert.Equal(t, problemArtifactVerifyKeys, problems[1])
+
+	// conf.LoadConfig refuses both keys, which is reported as a problem.
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(`{
+  "Servers": [{"ServerURL": "https://acme.mender.io"}],
+  "ArtifactVerifyKey": "/etc/mender/key.pem",
+  "ArtifactVerifyKeys": ["/etc/mender/other.pem"]
+}`), 0600))
+	err := validateConfigFile(&buf, confPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 problem(s)")
+	assert.Equal(t, problemArtifactVerifyKeys+"\n", buf.String())
+}
+
+func TestValidateConfigFields(t *testing.T) {
+	confPath := writeTestConfig(t, `{
+  "Servers": [{"ServerURL": "acme.mender.io"}],
+  "UpdatePollIntervalSeconds": 1800,
+  "RetryPollIntervalSeconds": 1,
+  "MinTLSVersion": "1.7"
+}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	// Only the valid poll interval is checked.
+	var buf bytes.Buffer
+	require.NoError(t, validateConfigFile(&buf, confPath,
+		"UpdatePollIntervalSeconds"))
+	assert.Equal(t, "Configuration is valid.\n", buf.String())
+
+	buf.Reset()
+	err := validateConfigFile(&buf, confPath,
+		"updatepollintervalseconds", "ServerURL")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 problem(s)")
+	assert.Equal(t, "Invalid server URL \"acme.mender.io\"\n", buf.String())
+
+	err = validateConfigFile(&buf, confPath, "TenantToken")
+	assert.ErrorContains(t, err, `Cannot validate the field "TenantToken"`)
+}
+
+func TestValidateConfigStdin(t *testing.T) {
+	stdin := os.Stdin
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdin = stdin }()
+	os.Stdin = stdinR
+
+	_, err = stdinW.WriteString(`{"UpdatePollIntervalSeconds": 2}`)
+	require.NoError(t, err)
+	stdinW.Close()
+
+	var buf bytes.Buffer
+	err = validateConfigFile(&buf, "-")
+	require.Error(t, err)
+	assert.Contains(t, buf.String(), "No server is configured")
+	assert.Contains(t, buf.String(), "UpdatePollIntervalSeconds is 2")
+}
+
+func TestPrintRedactedConfig(t *testing.T) {
+	confPath := writeTestConfig(t, `{
+  "Servers": [{"ServerURL": "https://acme.mender.io"}],
+  "TenantToken": "secret-token",
+  "ServerCertificate": "/etc/mender/server.crt",
+  "HttpsClient": {"Certificate": "/etc/mender/client.crt", "Key": "/etc/mender/client.key"},
+  "UpdatePollIntervalSeconds": 1800
+}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	var buf bytes.Buffer
+	require.NoError(t, printRedactedConfig(&buf, confPath))
+	output := buf.String()
+	assert.NotContains(t, output, "secret-token")
+	assert.NotContains(t, output, "/etc/mender/")
+	assert.Contains(t, output, `"TenantToken": "<redacted>"`)
+	assert.Contains(t, output, `"Key": "<redacted>"`)
+	assert.Contains(t, output, `"ServerURL": "https://acme.mender.io"`)
+	assert.Contains(t, output, `"UpdatePollIntervalSeconds": 1800`)
+}
+
+func TestPrintPaths(t *testing.T) {
+	oldConfDir := os.Getenv("MENDER_CONF_DIR")
+	defer func() {
+		os.Setenv("MENDER_CONF_DIR", oldConfDir)
+		conf.ReloadPaths()
+	}()
+	os.Setenv("MENDER_CONF_DIR", "/opt/mender/etc")
+	conf.ReloadPaths()
+
+	var buf bytes.Buffer
+	require.NoError(t, printPaths(&buf, true))
+	var paths map[string]string
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &paths))
+	assert.Equal(t, "/opt/mender/etc", paths["conf_dir"])
+	assert.Equal(t, "/opt/mender/etc/mender.conf", paths["conf_file"])
+	assert.Equal(t, "/opt/mender/etc/scripts", paths["rootfs_scripts"])
+	assert.Equal(t, conf.DefaultDataStore, paths["data_store"])
+	assert.Equal(t, conf.DefaultBootstrapArtifactFile,
+		paths["bootstrap_artifact"])
+
+	buf.Reset()
+	require.NoError(t, printPaths(&buf, false))
+	assert.Contains(t, buf.String(), "conf file:          /opt/mender/etc/mender.conf\n")
+}
+
+func TestConvertConfigFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	yamlPath := path.Join(tmpDir, "mender.yaml")
+	require.NoError(t, ioutil.WriteFile(yamlPath, []byte(`Servers:
+  - ServerURL: https://primary.mender.io
+  - ServerURL: https://secondary.mender.io
+ServerCertificate: /etc/mender/server.crt
+TenantToken: dummy-token
+UpdatePollIntervalSeconds: 1800
+InventoryPollIntervalSeconds: 28800
+RetryPollIntervalSeconds: 300
+ArtifactVerifyKeys:
+  - /etc/mender/artifact-verify-key.pem
+HttpsClient:
+  Certificate: /etc/mender/client.crt
+`), 0600))
+
+	jsonPath := path.Join(tmpDir, "mender.conf")
+	require.NoError(t, SetupCLI([]string{"mender-setup", "convert",
+		"--in", yamlPath, "--out", jsonPath}))
+
+	fromYAML, err := conf.LoadConfig(yamlPath, "")
+	require.NoError(t, err)
+	fromJSON, err := conf.LoadConfig(jsonPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, fromYAML.MenderConfigFromFile, fromJSON.MenderConfigFromFile)
+	assert.Equal(t, "dummy-token", fromJSON.TenantToken)
+	assert.Equal(t, 1800, fromJSON.UpdatePollIntervalSeconds)
+
+	// Fields setup does not know about are kept too.
+	data, err := ioutil.ReadFile(jsonPath)
+	require.NoError(t, err)
+	var converted map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &converted))
+	assert.Equal(t, map[string]interface{}{
+		"Certificate": "/etc/mender/client.crt",
+	}, converted["HttpsClient"])
+
+	// And back, with the format given explicitly.
+	yamlOut := path.Join(tmpDir, "mender.out")
+	require.NoError(t, convertConfigFile(jsonPath, yamlOut, conf.FormatYAML))
+	data, err = ioutil.ReadFile(yamlOut)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "UpdatePollIntervalSeconds: 1800\n")
+
+	assert.Error(t, convertConfigFile(jsonPath, yamlOut, "toml"))
+	assert.Error(t, convertConfigFile(path.Join(tmpDir, "missing.yaml"),
+		jsonPath, ""))
+}
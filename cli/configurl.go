@@ -0,0 +1,102 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// configURLDeviceType is replaced by the device type in a --config-url.
+const configURLDeviceType = "{device_type}"
+
+// applyConfigURL fetches the configuration of the device type from the
+// --config-url template, for zero-touch provisioning from a central server.
+// The fetched configuration replaces the local one, and is kept as with
+// --merge: only what is given with flags, such as another tenant token or
+// poll interval, is overlaid on it.
+func (opts *setupOptionsType) applyConfigURL(ctx *cli.Context) error {
+	if opts.configURL == "" {
+		return nil
+	}
+	if !ctx.IsSet("device-type") {
+		opts.deviceType = getDefaultDeviceType(ctx, opts.sanitizeHostname)
+		_ = ctx.Set("device-type", opts.deviceType)
+	}
+	configURL := strings.ReplaceAll(opts.configURL, configURLDeviceType,
+		url.PathEscape(opts.deviceType))
+	config, err := opts.fetchConfig(configURL)
+	if err != nil {
+		return err
+	}
+	if problems := validateConfig(config); len(problems) > 0 {
+		return errors.Errorf("The configuration from %s has %d problem(s): %s",
+			configURL, len(problems), strings.Join(problems, "; "))
+	}
+	log.Infof("Using the configuration from %s", configURL)
+	opts.fetchedConfig = config
+	opts.merge = true
+	return nil
+}
+
+// fetchConfig fetches the configuration at configURL, which is YAML if the
+// path has a YAML extension, and JSON otherwise.
+func (opts *setupOptionsType) fetchConfig(
+	configURL string) (*conf.MenderConfigFromFile, error) {
+	u, err := url.Parse(configURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		return nil, errors.Errorf("Invalid configuration URL %q: expected "+
+			"e.g. https://provisioning.example.com/%s.conf", configURL,
+			configURLDeviceType)
+	}
+	client, err := opts.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = opts.checkTimeoutDuration()
+	rsp, err := client.Get(configURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Configuration request to %s FAILED",
+			configURL)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Configuration request to %s FAILED: "+
+			"unexpected statuscode %d", configURL, rsp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading the configuration response")
+	}
+	format := conf.FormatFromExtension(u.Path)
+	if format == "" {
+		format = conf.FormatJSON
+	}
+	config, err := conf.LoadConfigReader(bytes.NewReader(body), format)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid configuration from %s",
+			configURL)
+	}
+	return &config.MenderConfigFromFile, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupConfigURL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			switch r.URL.Path {
+			case "/configs/acme-pi.conf":
+				w.Write([]byte(`{
+					"Servers": [{"ServerURL": "https://acme.mender.io"}],
+					"TenantToken": "acme-token",
+					"UpdatePollIntervalSeconds": 600,
+					"InventoryPollIntervalSeconds": 3600,
+					"RetryPollIntervalSeconds": 60
+				}`))
+			case "/configs/broken-pi.conf":
+				w.Write([]byte(`{
+					"Servers": [{"ServerURL": "https://acme.mender.io"}],
+					"UpdatePollIntervalSeconds": 1
+				}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer srv.Close()
+	setup := func(args ...string) error {
+		return SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--non-interactive", "--config", confPath, "--data", tmpDir,
+			"--config-url", srv.URL + "/configs/{device_type}.conf"},
+			args...))
+	}
+
+	require.NoError(t, setup("--device-type", "acme-pi"))
+	assert.Equal(t, []string{"/configs/acme-pi.conf"}, requested)
+	config, err := conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	require.Len(t, config.Servers, 1)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+	assert.Equal(t, "acme-token", config.TenantToken)
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, 3600, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, 60, config.RetryPollIntervalSeconds)
+	devType, err := ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=acme-pi\n", string(devType))
+
+	// The flags given are overlaid on it, and without --device-type the
+	// current one is used.
+	require.NoError(t, setup("--tenant-token", "other-token"))
+	config, err = conf.LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, "other-token", config.TenantToken)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+
+	err = setup("--device-type", "broken-pi")
+	assert.ErrorContains(t, err, "UpdatePollIntervalSeconds")
+	err = setup("--device-type", "other-pi")
+	assert.ErrorContains(t, err, "unexpected statuscode 404")
+	err = setup("--device-type", "acme-pi",
+		"--discovery-url", srv.URL+"/discovery")
+	assert.ErrorContains(t, err, "discovery-url")
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// discoveredCertFile is where the CA certificate from the discovery
+// endpoint is written, next to the configuration file.
+const discoveredCertFile = "server.crt"
+
+// discoveryResponse is the document returned by a --discovery-url.
+type discoveryResponse struct {
+	ServerURL string `json:"server_url"`
+	// PEM encoded CA certificate(s) for the server, if it does not use a
+	// certificate from a known authority.
+	CACert string `json:"ca_cert"`
+}
+
+// applyDiscovery fetches the server URL and certificate from the endpoint
+// given with --discovery-url, and uses them as if given with --server-url
+// and --server-cert.
+func (opts *setupOptionsType) applyDiscovery(ctx *cli.Context) error {
+	if opts.discoveryURL == "" {
+		return nil
+	}
+	for _, flag := range []string{"server-url", "server-ip", "server-cert",
+		"config-url"} {
+		if ctx.IsSet(flag) {
+			return errors.Errorf(errMsgConflictingArgumentsF,
+				"discovery-url", flag)
+		}
+	}
+	discovery, err := opts.fetchDiscovery()
+	if err != nil {
+		return err
+	}
+	log.Infof("Discovered the server %s", discovery.ServerURL)
+	_ = ctx.Set("server-url", discovery.ServerURL)
+	opts.serverURL = discovery.ServerURL
+	if discovery.CACert != "" {
+		opts.discoveredCert = []byte(discovery.CACert)
+		opts.serverCert = path.Join(path.Dir(opts.configPath), discoveredCertFile)
+		_ = ctx.Set("server-cert", opts.serverCert)
+		// Fail before the questions rather than when writing.
+		if _, err := opts.existingServerCert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opts *setupOptionsType) fetchDiscovery() (*discoveryResponse, error) {
+	client, err := opts.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = opts.checkTimeoutDuration()
+	rsp, err := client.Get(opts.discoveryURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Discovery request to %s FAILED",
+			opts.discoveryURL)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Discovery request to %s FAILED: "+
+			"unexpected statuscode %d", opts.discoveryURL, rsp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading the discovery response")
+	}
+
+	var discovery discoveryResponse
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		return nil, errors.Wrap(err, "Error parsing the discovery response")
+	}
+	if !isValidServerURL(discovery.ServerURL) {
+		return nil, errors.Errorf("The discovery response has an invalid "+
+			"server_url %q", discovery.ServerURL)
+	}
+	if discovery.CACert != "" && !isPEMCertificates([]byte(discovery.CACert)) {
+		return nil, errors.New("The discovery response has an invalid " +
+			"ca_cert: expected PEM encoded certificates")
+	}
+	return &discovery, nil
+}
+
+// isPEMCertificates returns true if data holds at least one PEM encoded
+// certificate, and nothing else.
+func isPEMCertificates(data []byte) bool {
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return false
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// existingServerCert returns the certificate at the ServerCertificate path
+// which the discovered one is to replace, or nil if there is none or it is
+// the same. A different one is only replaced with --force-server-cert.
+func (opts *setupOptionsType) existingServerCert() ([]byte, error) {
+	current, err := ioutil.ReadFile(opts.serverCert)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Error reading the existing server certificate")
+	}
+	if bytes.Equal(current, opts.discoveredCert) {
+		return nil, nil
+	}
+	if !opts.forceServerCert {
+		return nil, errors.Errorf("%s holds a different certificate than "+
+			"the discovered one; give --force-server-cert to replace it",
+			opts.serverCert)
+	}
+	return current, nil
+}
+
+// writeDiscoveredCert writes the CA certificate from the discovery endpoint
+// to the ServerCertificate path, backing up a different certificate there
+// to <path>.bak.
+func (opts *setupOptionsType) writeDiscoveredCert() error {
+	if opts.discoveredCert == nil {
+		return nil
+	}
+	current, err := opts.existingServerCert()
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		backupPath := opts.serverCert + ".bak"
+		if err := conf.WriteFileAtomic(backupPath, current, 0644); err != nil {
+			return errors.Wrap(err, "Error writing the server certificate backup")
+		}
+		log.Infof("Backed up the previous server certificate to %s", backupPath)
+	}
+	if err := conf.WriteFileAtomic(
+		opts.serverCert, opts.discoveredCert, 0644); err != nil {
+		return errors.Wrap(err, "Error writing the discovered server certificate")
+	}
+	return nil
+}
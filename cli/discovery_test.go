@@ -0,0 +1,213 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupDiscoveryURL(t *testing.T) {
+	caPEM, _ := newTestCA(t)
+	var response interface{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(response)
+		}))
+	defer srv.Close()
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	opts.discoveryURL = srv.URL
+
+	// Invalid responses are rejected
+	response = map[string]string{"server_url": "not a url"}
+	assert.Error(t, opts.applyDiscovery(ctx))
+	response = map[string]string{
+		"server_url": "https://acme.mender.io",
+		"ca_cert":    "not a certificate",
+	}
+	assert.Error(t, opts.applyDiscovery(ctx))
+	assert.False(t, ctx.IsSet("server-url"))
+
+	response = map[string]string{
+		"server_url": "https://acme.mender.io",
+		"ca_cert":    string(caPEM),
+	}
+	require.NoError(t, opts.applyDiscovery(ctx))
+	require.NoError(t, doSetup(ctx, config, opts))
+
+	loaded, err := conf.LoadConfig(opts.configPath, "")
+	require.NoError(t, err)
+	require.Len(t, loaded.Servers, 1)
+	assert.Equal(t, "https://acme.mender.io", loaded.Servers[0].ServerURL)
+	certPath := path.Join(path.Dir(opts.configPath), discoveredCertFile)
+	assert.Equal(t, certPath, loaded.ServerCertificate)
+	written, err := ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+	assert.Equal(t, caPEM, written)
+
+	// Cannot be combined with an explicit server
+	assert.Error(t, opts.applyDiscovery(ctx))
+}
+
+func TestWriteDiscoveredCertExisting(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	certPath := path.Join(tmpDir, discoveredCertFile)
+	opts := &setupOptionsType{
+		serverCert:     certPath,
+		discoveredCert: []byte("discovered"),
+	}
+
+	// The same certificate is left as it is
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("discovered"), 0644))
+	require.NoError(t, opts.writeDiscoveredCert())
+	assert.NoFileExists(t, certPath+".bak")
+
+	// A different one is only replaced when forced, after a backup
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("existing"), 0644))
+	assert.ErrorContains(t, opts.writeDiscoveredCert(), "--force-server-cert")
+	written, err := ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(written))
+
+	opts.forceServerCert = true
+	require.NoError(t, opts.writeDiscoveredCert())
+	written, err = ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+	assert.Equal(t, "discovered", string(written))
+	backup, err := ioutil.ReadFile(certPath + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(backup))
+}
+
+func TestSaveConfigOptionsStdoutDiscoveredCert(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	opts.serverURL = "https://acme.mender.io"
+	opts.demoIntervals = true
+	opts.toStdout = true
+	opts.warnings = newWarningSink()
+	opts.serverCert = path.Join(path.Dir(opts.configPath), discoveredCertFile)
+	opts.discoveredCert = []byte("discovered")
+
+	stdout := os.Stdout
+	stdoutR, stdoutW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdout = stdout }()
+	os.Stdout = stdoutW
+	err = opts.saveConfigOptions(config)
+	os.Stdout = stdout
+	stdoutW.Close()
+	require.NoError(t, err)
+	output, err := ioutil.ReadAll(stdoutR)
+	require.NoError(t, err)
+	assert.Contains(t, string(output), opts.serverCert)
+
+	// The certificate is for the other device too
+	assert.NoFileExists(t, opts.serverCert)
+	require.Len(t, opts.warnings.collected(), 1)
+	assert.Contains(t, opts.warnings.collected()[0], "discovered")
+}
+
+func TestSetupDiscoveryInvalidRequestFlags(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+	defer srv.Close()
+
+	// The flags are checked before the discovery request is made.
+	for _, args := range [][]string{
+		{"--check-timeout", "-1"},
+		{"--proxy", "proxy.example.com"},
+	} {
+		err := SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--config", path.Join(tmpDir, "mender.conf"), "--data", tmpDir,
+			"--device-type", "acme-pi", "--demo-polling",
+			"--discovery-url", srv.URL}, args...))
+		assert.ErrorContains(t, err, "Invalid", args)
+	}
+	assert.False(t, requested)
+}
+
+func TestSetupDiscoveryClientCADir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+
+	caPEM, serverCert := newTestCA(t)
+	caDir := path.Join(tmpDir, "certs")
+	require.NoError(t, os.Mkdir(caDir, 0755))
+	require.NoError(t, ioutil.WriteFile(
+		path.Join(caDir, "signer.crt"), caPEM, 0644))
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"server_url": "https://acme.mender.io",
+				"ca_cert":    string(caPEM),
+			})
+		}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The discovery server is only trusted through --client-ca-dir.
+	configPath := path.Join(tmpDir, "mender.conf")
+	err = SetupCLI([]string{"mender-setup", "--quiet",
+		"--config", configPath, "--data", tmpDir,
+		"--device-type", "acme-pi", "--demo-polling",
+		"--discovery-url", srv.URL, "--client-ca-dir", caDir})
+	require.NoError(t, err)
+	loaded, err := conf.LoadConfig(configPath, "")
+	require.NoError(t, err)
+	require.Len(t, loaded.Servers, 1)
+	assert.Equal(t, "https://acme.mender.io", loaded.Servers[0].ServerURL)
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// logDryRunWrite logs, for --dry-run, the file which would be written.
+func logDryRunWrite(filePath string, data []byte) {
+	log.Infof("Dry run: would write %s:\n%s", filePath, data)
+}
+
+// reportConfigFiles logs the files writeConfigFiles would write, for
+// --dry-run, without touching any of them.
+func (opts *setupOptionsType) reportConfigFiles(
+	config *conf.MenderConfigFromFile) error {
+	if opts.discoveredCert != nil {
+		logDryRunWrite(opts.serverCert, opts.discoveredCert)
+	}
+	if _, err := os.Stat(opts.configPath); err == nil && !opts.noBackup {
+		log.Infof("Dry run: would back up %s to %s.bak",
+			opts.configPath, opts.configPath)
+	}
+	data, err := opts.marshalConfig(config)
+	if err != nil {
+		return err
+	}
+	logDryRunWrite(opts.configPath, data)
+	if opts.writeChecksum {
+		log.Infof("Dry run: would write the checksum to %s.sha256",
+			opts.configPath)
+	}
+	logDryRunWrite(config.DeviceTypeFile, opts.deviceTypeFileData())
+	if opts.envFile != "" {
+		log.Infof("Dry run: would write the environment file %s",
+			opts.envFile)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupDryRun(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	oldDefaultHostsFile := DefaultHostsFile
+	DefaultHostsFile = path.Join(tdir, "hosts")
+	oldDefaultLocalTrustMenderDir := DefaultLocalTrustMenderDir
+	DefaultLocalTrustMenderDir = path.Join(tdir, "trust")
+	oldDefaultMenderDemoCertDir := DefaultMenderDemoCertDir
+	DefaultMenderDemoCertDir = tdir
+	defer func() {
+		DefaultHostsFile = oldDefaultHostsFile
+		DefaultLocalTrustMenderDir = oldDefaultLocalTrustMenderDir
+		DefaultMenderDemoCertDir = oldDefaultMenderDemoCertDir
+	}()
+	const hosts = "127.0.0.1 localhost\n"
+	require.NoError(t, ioutil.WriteFile(DefaultHostsFile, []byte(hosts), 0644))
+	demoCert := newTestCertExpiringAt(t, time.Now().Add(365*24*time.Hour))
+	require.NoError(t, ioutil.WriteFile(getMenderDemoCertPath(), demoCert, 0644))
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetLevel(log.GetLevel())
+
+	confPath := path.Join(tdir, "mender.conf")
+	err = SetupCLI([]string{"mender-setup", "--dry-run", "--assume-yes",
+		"--config", confPath, "--data", tdir,
+		"--device-type", "dev-pi", "--demo", "--server-ip", "10.0.0.2"})
+	require.NoError(t, err)
+
+	// Nothing is written...
+	for _, file := range []string{confPath, path.Join(tdir, "device_type"),
+		DefaultLocalTrustMenderDir} {
+		_, err := os.Stat(file)
+		assert.True(t, os.IsNotExist(err), file)
+	}
+	content, err := ioutil.ReadFile(DefaultHostsFile)
+	require.NoError(t, err)
+	assert.Equal(t, hosts, string(content))
+
+	// ...but reported.
+	assert.Contains(t, buf.String(), "Dry run: would write "+confPath)
+	assert.Contains(t, buf.String(), "https://docker.mender.io")
+	assert.Contains(t, buf.String(), "Dry run: would write "+
+		path.Join(tdir, "device_type"))
+	assert.Contains(t, buf.String(), "Dry run: would write "+DefaultHostsFile)
+	assert.Contains(t, buf.String(), "10.0.0.2        docker.mender.io")
+	assert.Contains(t, buf.String(), "Dry run: would write "+
+		path.Join(DefaultLocalTrustMenderDir, "mender-demo-1.crt"))
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// envFileVariable is a line of the file written with --write-env-file.
+type envFileVariable struct {
+	name   string
+	value  string
+	secret bool
+}
+
+// envFileVariables returns the settings of the setup result, for init
+// systems which configure the client through an EnvironmentFile. Settings
+// which are not set are left out.
+func envFileVariables(config *conf.MenderConfigFromFile,
+	deviceType string) []envFileVariable {
+	serverURL := config.ServerURL
+	if len(config.Servers) > 0 {
+		serverURL = config.Servers[0].ServerURL
+	}
+	tenantToken := config.TenantToken
+	if tenantToken == "" && len(config.Servers) > 0 {
+		tenantToken = config.Servers[0].TenantToken
+	}
+	itoa := func(i int) string {
+		if i == 0 {
+			return ""
+		}
+		return strconv.Itoa(i)
+	}
+	variables := []envFileVariable{}
+	for _, v := range []envFileVariable{
+		{"MENDER_DEVICE_TYPE", deviceType, false},
+		{"MENDER_SERVER_URL", serverURL, false},
+		{"MENDER_SERVER_CERTIFICATE", config.ServerCertificate, false},
+		{"MENDER_TENANT_TOKEN", tenantToken, true},
+		{"MENDER_UPDATE_POLL_INTERVAL_SECONDS",
+			itoa(config.UpdatePollIntervalSeconds), false},
+		{"MENDER_INVENTORY_POLL_INTERVAL_SECONDS",
+			itoa(config.InventoryPollIntervalSeconds), false},
+		{"MENDER_RETRY_POLL_INTERVAL_SECONDS",
+			itoa(config.RetryPollIntervalSeconds), false},
+	} {
+		if v.value != "" {
+			variables = append(variables, v)
+		}
+	}
+	return variables
+}
+
+// writeEnvFile writes the settings of the setup result to filePath as
+// KEY=value lines. A file with secrets is only readable by its owner.
+func writeEnvFile(filePath string, config *conf.MenderConfigFromFile,
+	deviceType string) error {
+	var b strings.Builder
+	var mode os.FileMode = 0644
+	for _, v := range envFileVariables(config, deviceType) {
+		fmt.Fprintf(&b, "%s=%s\n", v.name, shellQuote(v.value))
+		if v.secret {
+			mode = 0600
+		}
+	}
+	// The new file has its mode before the secrets are written to it,
+	// whatever the mode of an existing one.
+	if err := conf.WriteFileAtomic(
+		filePath, []byte(b.String()), mode); err != nil {
+		return errors.Wrapf(err, "Error writing the environment file %q",
+			filePath)
+	}
+	return nil
+}
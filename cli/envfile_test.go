@@ -0,0 +1,61 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupWriteEnvFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	envPath := path.Join(tmpDir, "mender.env")
+	defer log.SetLevel(log.GetLevel())
+
+	args := []string{"mender-setup", "--quiet",
+		"--config", confPath, "--data", tmpDir, "--write-env-file", envPath,
+		"--device-type", "acme-pi",
+		"--server-url", "https://acme.mender.io", "--server-cert", "",
+		"--update-poll", "1800", "--inventory-poll", "28800",
+		"--retry-poll", "300"}
+	require.NoError(t, SetupCLI(args))
+	data, err := ioutil.ReadFile(envPath)
+	require.NoError(t, err)
+	assert.Equal(t, "MENDER_DEVICE_TYPE=acme-pi\n"+
+		"MENDER_SERVER_URL=https://acme.mender.io\n"+
+		"MENDER_UPDATE_POLL_INTERVAL_SECONDS=1800\n"+
+		"MENDER_INVENTORY_POLL_INTERVAL_SECONDS=28800\n"+
+		"MENDER_RETRY_POLL_INTERVAL_SECONDS=300\n", string(data))
+	info, err := os.Stat(envPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// With the tenant token, only the owner may read the file.
+	require.NoError(t, SetupCLI(append(args, "--tenant-token", "dummy-token")))
+	data, err = ioutil.ReadFile(envPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "MENDER_TENANT_TOKEN=dummy-token\n")
+	info, err = os.Stat(envPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
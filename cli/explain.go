@@ -0,0 +1,108 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explanations printed with --explain after the answers to the prompts.
+const (
+	explainDeviceTypeF = "Device type: only Artifacts built for %q " +
+		"will be installed on this device."
+	explainHostedMender = "Hosted Mender: the device connects to " +
+		hostedMenderURL + " and identifies your organization with " +
+		"a tenant token."
+	explainOwnServer = "Own server: the device connects to a Mender " +
+		"server which you run."
+	explainDemoServer = "Demo server: a self-signed cert will be trusted " +
+		"and /etc/hosts edited."
+	explainProductionServer = "Production server: the server must " +
+		"present a certificate which the device trusts."
+	explainServerURLF = "Server URL: the device connects to %s, " +
+		"trying the servers in order."
+	explainServerIPF = "Server IP: /etc/hosts resolves the server " +
+		"host to %s, as the demo server has no DNS record."
+	explainServerCertF = "Server certificate: the device trusts the " +
+		"server because of %s."
+	explainServerCertSystem = "Server certificate: the device trusts " +
+		"the server through the system certificate store."
+	explainCredentials = "Credentials: used once to fetch the tenant " +
+		"token; they are not stored on the device."
+	explainDemoPollingF = "Polling: demo intervals check for updates " +
+		"every %ds, which suits a test device but not a fleet."
+	explainPollingF = "Polling: the device checks for updates every %ds " +
+		"and sends its inventory every %ds."
+	explainMTLS = "Mutual TLS: the device proves its identity to the " +
+		"server with the client certificate."
+	explainNoClientCert = "Mutual TLS: the server does not ask the " +
+		"device for a client certificate."
+	explainConnectivity = "Connectivity: the client keeps its " +
+		"connections to the server alive as configured."
+	explainStateScripts = "State scripts: the timeouts bound how long " +
+		"the scripts of an update may run."
+	explainArtifactKeys = "Artifact verification: only Artifacts signed " +
+		"with the given keys will be installed."
+)
+
+// explanation returns the rationale of the answer given in state.
+func (opts *setupOptionsType) explanation(state int) string {
+	switch state {
+	case stateDeviceType:
+		return fmt.Sprintf(explainDeviceTypeF, opts.deviceType)
+	case stateHostedMender:
+		if opts.hostedMender {
+			return explainHostedMender
+		}
+		return explainOwnServer
+	case stateDemoServer:
+		if opts.demoServer {
+			return explainDemoServer
+		}
+		return explainProductionServer
+	case stateServerURL:
+		servers := append([]string{opts.serverURL}, opts.fallbackServers...)
+		return fmt.Sprintf(explainServerURLF, strings.Join(servers, ", "))
+	case stateServerIP:
+		return fmt.Sprintf(explainServerIPF, opts.serverIP)
+	case stateServerCert:
+		if opts.serverCert == "" {
+			return explainServerCertSystem
+		}
+		return fmt.Sprintf(explainServerCertF, opts.serverCert)
+	case stateCredentials:
+		return explainCredentials
+	case statePolling:
+		if opts.demoIntervals {
+			return fmt.Sprintf(explainDemoPollingF, demoUpdatePoll)
+		}
+		return fmt.Sprintf(explainPollingF,
+			opts.updatePollInterval, opts.invPollInterval)
+	case stateClientCert:
+		if opts.clientCert == "" {
+			return explainNoClientCert
+		}
+		return explainMTLS
+	case stateMTLS:
+		return explainMTLS
+	case stateConnectivity:
+		return explainConnectivity
+	case stateStateScripts:
+		return explainStateScripts
+	case stateArtifactKeys:
+		return explainArtifactKeys
+	}
+	return ""
+}
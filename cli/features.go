@@ -0,0 +1,296 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// Optional areas which can be selected with --select-features.
+const (
+	featureMTLS         = "mtls"
+	featureConnectivity = "connectivity"
+	featureStateScripts = "state-scripts"
+	featureArtifactKeys = "artifact-keys"
+)
+
+// optionalFeatures are the areas offered for selection, in the order their
+// states run, after the poll intervals.
+var optionalFeatures = []struct {
+	name        string
+	description string
+	state       int
+}{
+	{featureMTLS, "Mutual TLS client certificate", stateMTLS},
+	{featureConnectivity, "Connectivity (keep-alive and idle connections)",
+		stateConnectivity},
+	{featureStateScripts, "State script timeouts", stateStateScripts},
+	{featureArtifactKeys, "Artifact verification keys", stateArtifactKeys},
+}
+
+const (
+	promptSelectFeatures = "\nSelect the optional areas to configure, as " +
+		"numbers separated by spaces or commas, or leave blank for none:\n"
+	promptClientCert = "\nSet the location of the client certificate for " +
+		"mutual TLS (filepath, for example /etc/mender/client.crt): "
+	promptClientKey = "Set the location of the client certificate's " +
+		"private key (filepath, for example /etc/mender/client.key): "
+	promptUseClientCert = "\nDoes the server require a client certificate " +
+		"(mutual TLS)? [y/N] "
+	promptSSLEngine = "Set the OpenSSL engine holding the private key, " +
+		"or leave blank to read it from a file: "
+	promptDisableKeepAlive = "\nDo you want to disable persistent " +
+		"(keep-alive) connections to the server? [y/N] "
+	promptIdleConnTimeout = "Set the number of seconds after which an idle " +
+		"connection is closed, or leave blank for the client default: "
+	promptStateScriptTimeout = "\nSet the state script timeout in seconds, " +
+		"or leave blank for the client default: "
+	promptStateScriptRetryTimeout = "Set the total time in seconds to retry " +
+		"a state script, or leave blank for the client default: "
+	promptStateScriptRetryInterval = "Set the interval in seconds between " +
+		"state script retries, or leave blank for the client default: "
+	promptArtifactKeys = "\nSet the locations of the Artifact verification " +
+		"keys, separated by commas: "
+
+	rspInvalidSelection = "Please enter numbers between 1 and %d: "
+	rspEmptyPath        = "Please enter a file path: "
+)
+
+// askFeatures lets the operator choose which optional areas to configure;
+// their states run after the poll intervals.
+func (opts *setupOptionsType) askFeatures(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	prompt := promptSelectFeatures
+	for i, feature := range optionalFeatures {
+		prompt += fmt.Sprintf("  %d) %s\n", i+1, feature.description)
+	}
+	rsp, err := stdin.promptUser(prompt+"> ", false)
+	for {
+		if err != nil {
+			return stateInvalid, err
+		}
+		selected, ok := parseFeatureSelection(rsp)
+		if ok {
+			opts.features = selected
+			break
+		}
+		rsp, err = stdin.promptUser(fmt.Sprintf(
+			rspInvalidSelection, len(optionalFeatures)), false)
+	}
+	return stateDeviceType, nil
+}
+
+// parseFeatureSelection parses a selection such as "1, 3" into the set of
+// chosen feature names.
+func parseFeatureSelection(rsp string) (map[string]bool, bool) {
+	selected := map[string]bool{}
+	fields := strings.FieldsFunc(rsp, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > len(optionalFeatures) {
+			return nil, false
+		}
+		selected[optionalFeatures[n-1].name] = true
+	}
+	return selected, true
+}
+
+// nextFeatureState returns the state of the first selected feature after
+// the given state, or stateDone if there are no more.
+func (opts *setupOptionsType) nextFeatureState(after int) int {
+	passed := after == statePolling
+	for _, feature := range optionalFeatures {
+		if passed && opts.features[feature.name] {
+			return feature.state
+		}
+		if feature.state == after {
+			passed = true
+		}
+	}
+	return stateDone
+}
+
+func (opts *setupOptionsType) askMTLS(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	if err := opts.promptClientCertAndKey(stdin); err != nil {
+		return stateInvalid, err
+	}
+	return opts.nextFeatureState(stateMTLS), nil
+}
+
+// askClientCert asks for the client certificate of a production server over
+// https, following the server certificate. It is only asked when the server
+// certificate was, and not when --client-cert is given or mutual TLS was
+// selected with --select-features, which asks for it later on.
+func (opts *setupOptionsType) askClientCert(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	if opts.hostedMender || opts.demoServer || !isHTTPS(opts.serverURL) ||
+		ctx.IsSet("client-cert") || opts.features[featureMTLS] {
+		return statePolling, nil
+	}
+	useClientCert, err := stdin.promptYN(promptUseClientCert, false)
+	if err != nil {
+		return stateInvalid, err
+	}
+	if !useClientCert {
+		opts.clientCert, opts.clientKey, opts.sslEngine = "", "", ""
+		return statePolling, nil
+	}
+	if err = opts.promptClientCertAndKey(stdin); err != nil {
+		return stateInvalid, err
+	}
+	return statePolling, nil
+}
+
+// promptClientCertAndKey asks for the client certificate, the OpenSSL engine
+// and the private key, which is only checked to exist as a file when no
+// engine holds it.
+func (opts *setupOptionsType) promptClientCertAndKey(stdin *stdinReader) error {
+	var err error
+	if opts.clientCert, err = promptExistingFile(
+		stdin, promptClientCert); err != nil {
+		return err
+	}
+	if opts.sslEngine, err = stdin.promptUser(
+		promptSSLEngine, false); err != nil {
+		return err
+	}
+	if opts.sslEngine != "" {
+		// The key is an identifier within the engine, not a file.
+		opts.clientKey, err = promptPath(stdin, promptClientKey)
+	} else {
+		opts.clientKey, err = promptExistingFile(stdin, promptClientKey)
+	}
+	return err
+}
+
+func (opts *setupOptionsType) askConnectivity(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	disable, err := stdin.promptYN(promptDisableKeepAlive, false)
+	if err != nil {
+		return stateInvalid, err
+	}
+	timeout, err := promptOptionalSeconds(stdin, promptIdleConnTimeout)
+	if err != nil {
+		return stateInvalid, err
+	}
+	opts.connectivity = conf.Connectivity{
+		DisableKeepAlive:       disable,
+		IdleConnTimeoutSeconds: timeout,
+	}
+	return opts.nextFeatureState(stateConnectivity), nil
+}
+
+func (opts *setupOptionsType) askStateScripts(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	var err error
+	for _, setting := range []struct {
+		prompt string
+		value  *int
+	}{
+		{promptStateScriptTimeout, &opts.stateScriptTimeout},
+		{promptStateScriptRetryTimeout, &opts.stateScriptRetryTimeout},
+		{promptStateScriptRetryInterval, &opts.stateScriptRetryInterval},
+	} {
+		if *setting.value, err = promptOptionalSeconds(
+			stdin, setting.prompt); err != nil {
+			return stateInvalid, err
+		}
+	}
+	return opts.nextFeatureState(stateStateScripts), nil
+}
+
+func (opts *setupOptionsType) askArtifactKeys(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	rsp, err := promptPath(stdin, promptArtifactKeys)
+	if err != nil {
+		return stateInvalid, err
+	}
+	opts.artifactKeys = nil
+	for _, key := range strings.Split(rsp, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			opts.artifactKeys = append(opts.artifactKeys, key)
+		}
+	}
+	return opts.nextFeatureState(stateArtifactKeys), nil
+}
+
+// saveFeatures writes the settings of the selected optional areas.
+func (opts *setupOptionsType) saveFeatures(config *conf.MenderConfigFromFile) {
+	if opts.features[featureMTLS] {
+		config.HttpsClient.Certificate = opts.clientCert
+		config.HttpsClient.Key = opts.clientKey
+		config.HttpsClient.SSLEngine = opts.sslEngine
+	}
+	if opts.features[featureConnectivity] {
+		config.Connectivity = opts.connectivity
+	}
+	if opts.features[featureStateScripts] {
+		config.StateScriptTimeoutSeconds = opts.stateScriptTimeout
+		config.StateScriptRetryTimeoutSeconds = opts.stateScriptRetryTimeout
+		config.StateScriptRetryIntervalSeconds = opts.stateScriptRetryInterval
+	}
+	if opts.features[featureArtifactKeys] {
+		config.ArtifactVerifyKey = ""
+		config.ArtifactVerifyKeys = opts.artifactKeys
+	}
+}
+
+// promptPath prompts until a non-empty response is given.
+func promptPath(stdin *stdinReader, prompt string) (string, error) {
+	rsp, err := stdin.promptUser(prompt, false)
+	for err == nil && strings.TrimSpace(rsp) == "" {
+		rsp, err = stdin.promptUser(rspEmptyPath, false)
+	}
+	return strings.TrimSpace(rsp), err
+}
+
+// promptExistingFile prompts until the path of an existing file is given.
+func promptExistingFile(stdin *stdinReader, prompt string) (string, error) {
+	rsp, err := promptPath(stdin, prompt)
+	for err == nil {
+		if _, statErr := os.Stat(rsp); statErr == nil {
+			break
+		}
+		rsp, err = promptPath(stdin, fmt.Sprintf(rspFileNotExist, rsp))
+	}
+	return rsp, err
+}
+
+// promptOptionalSeconds prompts for a number of seconds, where a blank
+// response gives 0, leaving the setting to the client default.
+func promptOptionalSeconds(stdin *stdinReader, prompt string) (int, error) {
+	rsp, err := stdin.promptUser(prompt, false)
+	for {
+		if err != nil {
+			return 0, err
+		}
+		if rsp == "" {
+			return 0, nil
+		}
+		if seconds, err := strconv.Atoi(rsp); err == nil && seconds >= 0 {
+			return seconds, nil
+		}
+		rsp, err = stdin.promptUser(rspNotSeconds, false)
+	}
+}
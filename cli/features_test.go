@@ -0,0 +1,189 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupSelectFeatures(t *testing.T) {
+	stdin := os.Stdin
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdin = stdin }()
+	os.Stdin = stdinR
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.selectFeatures = true
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "https://acme.mender.io")
+	opts.serverURL = "https://acme.mender.io"
+	ctx.Set("server-cert", "")
+
+	// Only the connectivity prompts may run; any other prompt reads EOF.
+	stdinW.WriteString("5\n")   // Selection? (invalid)
+	stdinW.WriteString("2\n")   // Selection?
+	stdinW.WriteString("y\n")   // Disable keep-alive?
+	stdinW.WriteString("120\n") // Idle connection timeout?
+	stdinW.Close()
+
+	err = doSetup(ctx, config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{featureConnectivity: true}, opts.features)
+
+	loaded, err := conf.LoadConfig(opts.configPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, conf.Connectivity{
+		DisableKeepAlive:       true,
+		IdleConnTimeoutSeconds: 120,
+	}, loaded.Connectivity)
+	assert.Equal(t, conf.HttpsClient{}, loaded.HttpsClient)
+	assert.Equal(t, 0, loaded.StateScriptTimeoutSeconds)
+	assert.Empty(t, loaded.ArtifactVerifyKeys)
+}
+
+func TestSetupSelectAllFeatures(t *testing.T) {
+	stdin := os.Stdin
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdin = stdin }()
+	os.Stdin = stdinR
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	tmpDir := path.Dir(runOptions.setupOptions.configPath)
+	defer os.RemoveAll(tmpDir)
+	opts := &runOptions.setupOptions
+	opts.selectFeatures = true
+	certPath := path.Join(tmpDir, "client.crt")
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("cert"), 0600))
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "https://acme.mender.io")
+	opts.serverURL = "https://acme.mender.io"
+	ctx.Set("server-cert", "")
+
+	stdinW.WriteString("4, 1 3\n")                 // Selection?
+	stdinW.WriteString("/etc/mender/client.crt\n") // Client certificate?
+	stdinW.WriteString(certPath + "\n")            // Existing one?
+	stdinW.WriteString("pkcs11\n")                 // SSL engine?
+	stdinW.WriteString("pkcs11:object=client\n")   // Client key?
+	stdinW.WriteString("3600\n")                   // State script timeout?
+	stdinW.WriteString("\n")                       // Retry timeout? (default)
+	stdinW.WriteString("60\n")                     // Retry interval?
+	stdinW.WriteString("/a.pem, /b.pem\n")         // Artifact keys?
+	stdinW.Close()
+
+	err = doSetup(ctx, config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, certPath, config.HttpsClient.Certificate)
+	assert.Equal(t, "pkcs11", config.HttpsClient.SSLEngine)
+	assert.Equal(t, "pkcs11:object=client", config.HttpsClient.Key)
+	assert.Equal(t, conf.Connectivity{}, config.Connectivity)
+	assert.Equal(t, 3600, config.StateScriptTimeoutSeconds)
+	assert.Equal(t, 0, config.StateScriptRetryTimeoutSeconds)
+	assert.Equal(t, 60, config.StateScriptRetryIntervalSeconds)
+	assert.Equal(t, []string{"/a.pem", "/b.pem"}, config.ArtifactVerifyKeys)
+}
+
+func TestSetupClientCert(t *testing.T) {
+	stdin := os.Stdin
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdin = stdin }()
+	os.Stdin = stdinR
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	tmpDir := path.Dir(runOptions.setupOptions.configPath)
+	defer os.RemoveAll(tmpDir)
+	opts := &runOptions.setupOptions
+	certPath := path.Join(tmpDir, "client.crt")
+	keyPath := path.Join(tmpDir, "client.key")
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("cert"), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, []byte("key"), 0600))
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "https://acme.mender.io")
+	opts.serverURL = "https://acme.mender.io"
+
+	stdinW.WriteString("\n")                    // Server certificate?
+	stdinW.WriteString("y\n")                   // Client certificate?
+	stdinW.WriteString("/missing/client.crt\n") // Certificate path
+	stdinW.WriteString(certPath + "\n")         // Certificate path (retry)
+	stdinW.WriteString("\n")                    // SSL engine? (none)
+	stdinW.WriteString(keyPath + "\n")          // Key path
+	require.NoError(t, doSetup(ctx, config, opts))
+	assert.Equal(t, conf.HttpsClient{
+		Certificate: certPath,
+		Key:         keyPath,
+	}, config.HttpsClient)
+
+	// The key is an identifier within the engine.
+	stdinW.WriteString("\n")                     // Server certificate?
+	stdinW.WriteString("y\n")                    // Client certificate?
+	stdinW.WriteString(certPath + "\n")          // Certificate path
+	stdinW.WriteString("pkcs11\n")               // SSL engine?
+	stdinW.WriteString("pkcs11:object=device\n") // Key identifier
+	stdinW.Close()
+	require.NoError(t, doSetup(ctx, config, opts))
+	assert.Equal(t, conf.HttpsClient{
+		Certificate: certPath,
+		Key:         "pkcs11:object=device",
+		SSLEngine:   "pkcs11",
+	}, config.HttpsClient)
+
+	// The key cannot be given without the certificate.
+	opts.clientCert = ""
+	assert.ErrorContains(t, opts.validateFlags(), "--client-cert and --client-key")
+
+	// The files must exist, except for a key within an SSL engine.
+	opts.clientCert = "/missing/client.crt"
+	assert.ErrorContains(t, opts.validateFlags(),
+		`Client certificate "/missing/client.crt" does not exist`)
+	opts.clientCert = certPath
+	assert.NoError(t, opts.validateFlags())
+	opts.sslEngine = ""
+	assert.ErrorContains(t, opts.validateFlags(),
+		`Client key "pkcs11:object=device" does not exist`)
+	opts.clientKey = keyPath
+	assert.NoError(t, opts.validateFlags())
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.Errorf("Invalid TLS version %q: must be one of "+
+			"1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// parseProxyURL parses the --proxy URL, which must be absolute.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("Invalid proxy URL %q: expected e.g. "+
+			"http://proxy.example.com:3128", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("Invalid proxy URL %q: the scheme must "+
+			"be one of http, https or socks5", proxy)
+	}
+	return u, nil
+}
+
+// newHTTPClient creates the client used for setup's own requests (e.g.
+// logging in to Hosted Mender), as opposed to the client configuration
+// written for the device. Like the default transport it uses the proxy from
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY, unless --proxy is given.
+func (opts *setupOptionsType) newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.proxy != "" {
+		u, err := parseProxyURL(opts.proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	pool, err := opts.clientCAPool()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	return &http.Client{Transport: transport}, nil
+}
+
+// tlsConfig returns the TLS configuration of setup's own connections, with
+// the --min-tls-version.
+func (opts *setupOptionsType) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if opts.minTLSVersion != "" {
+		v, err := parseTLSVersion(opts.minTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		config.MinVersion = v
+	}
+	return config, nil
+}
+
+// clientCAPool returns the pool of the CA certificates from --client-ca-bundle
+// and --client-ca-dir together, or nil for the system trust when neither is
+// given.
+func (opts *setupOptionsType) clientCAPool() (*x509.CertPool, error) {
+	if opts.clientCABundle == "" && len(opts.clientCADirs) == 0 {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if opts.clientCABundle != "" {
+		if err := appendCABundle(pool, opts.clientCABundle); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range opts.clientCADirs {
+		if err := appendCADir(pool, dir); err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// appendCABundle adds the PEM certificates in path to pool.
+func appendCABundle(pool *x509.CertPool, path string) error {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot read CA bundle %q", path)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.Errorf("No certificates found in CA bundle %q", path)
+	}
+	return nil
+}
+
+// appendCADir adds the PEM certificates in the files of dir to pool, as
+// for CAs distributed as a directory of files. Files which are not PEM
+// certificates are skipped, but the directory must hold at least one.
+func appendCADir(pool *x509.CertPool, dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot read CA directory %q", dir)
+	}
+	found := false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		pem, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "Cannot read CA directory %q", dir)
+		}
+		if pool.AppendCertsFromPEM(pem) {
+			found = true
+		}
+	}
+	if !found {
+		return errors.Errorf("No certificates found in CA directory %q", dir)
+	}
+	return nil
+}
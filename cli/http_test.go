@@ -0,0 +1,256 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// trustTestServer makes client trust the certificate of srv.
+func trustTestServer(client *http.Client, srv *httptest.Server) {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+}
+
+func TestHTTPClientMinTLSVersion(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	srv.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS11,
+		MaxVersion: tls.VersionTLS11,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	opts := &setupOptionsType{minTLSVersion: "1.2"}
+	client, err := opts.newHTTPClient()
+	require.NoError(t, err)
+	trustTestServer(client, srv)
+	_, err = client.Get(srv.URL)
+	assert.Error(t, err)
+
+	modernSrv := httptest.NewTLSServer(srv.Config.Handler)
+	defer modernSrv.Close()
+	trustTestServer(client, modernSrv)
+	rsp, err := client.Get(modernSrv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	opts = &setupOptionsType{minTLSVersion: "1.3.1"}
+	_, err = opts.newHTTPClient()
+	assert.Error(t, err)
+	assert.Error(t, opts.validateFlags())
+}
+
+// newTestCA creates a CA, and a server certificate for 127.0.0.1 signed by
+// it, returning the PEM encoded CA certificate and the server certificate.
+func newTestCA(t *testing.T) ([]byte, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, caCert, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return caPEM, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestHTTPClientCABundle(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	caPEM, serverCert := newTestCA(t)
+	bundle := path.Join(tdir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, caPEM, 0644))
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// Not trusted by the system...
+	opts := &setupOptionsType{}
+	client, err := opts.newHTTPClient()
+	require.NoError(t, err)
+	_, err = client.Get(srv.URL)
+	assert.Error(t, err)
+
+	// ...but by the bundle.
+	opts = &setupOptionsType{clientCABundle: bundle}
+	require.NoError(t, opts.validateFlags())
+	client, err = opts.newHTTPClient()
+	require.NoError(t, err)
+	rsp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// A bundle without certificates is rejected up front.
+	empty := path.Join(tdir, "empty.pem")
+	require.NoError(t, ioutil.WriteFile(empty, []byte("nothing"), 0644))
+	opts = &setupOptionsType{clientCABundle: empty}
+	assert.Error(t, opts.validateFlags())
+	_, err = opts.newHTTPClient()
+	assert.Error(t, err)
+}
+
+func TestHTTPClientCADir(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	caPEM, serverCert := newTestCA(t)
+	otherPEM, _ := newTestCA(t)
+	caDir := path.Join(tdir, "certs")
+	require.NoError(t, os.Mkdir(caDir, 0755))
+	require.NoError(t, ioutil.WriteFile(
+		path.Join(caDir, "other.pem"), otherPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(
+		path.Join(caDir, "signer.crt"), caPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(
+		path.Join(caDir, "README"), []byte("not a certificate"), 0644))
+	emptyDir := path.Join(tdir, "empty")
+	require.NoError(t, os.Mkdir(emptyDir, 0755))
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	opts := &setupOptionsType{clientCADirs: []string{caDir}}
+	require.NoError(t, opts.validateFlags())
+	client, err := opts.newHTTPClient()
+	require.NoError(t, err)
+	rsp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// Together with a bundle not holding the signer.
+	bundle := path.Join(tdir, "other.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, otherPEM, 0644))
+	opts = &setupOptionsType{clientCABundle: bundle,
+		clientCADirs: []string{caDir}}
+	require.NoError(t, opts.validateFlags())
+	client, err = opts.newHTTPClient()
+	require.NoError(t, err)
+	rsp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+
+	// A directory without certificates is rejected up front.
+	opts = &setupOptionsType{clientCADirs: []string{emptyDir}}
+	assert.ErrorContains(t, opts.validateFlags(), "No certificates found")
+	opts = &setupOptionsType{clientCADirs: []string{path.Join(tdir, "none")}}
+	assert.ErrorContains(t, opts.validateFlags(), "Cannot read CA directory")
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	// Acts as a forward proxy for the (unresolvable) Hosted Mender API.
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			proxied = append(proxied, r.URL.Host+r.URL.Path)
+			switch r.URL.Path {
+			case "/api/management/v1/useradm/auth/login":
+				w.Write([]byte("user-token"))
+			case "/api/management/v1/tenantadm/user/tenant":
+				w.Write([]byte(`{"tenant_token": "proxied-token"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer proxy.Close()
+
+	oldHostedMenderAPIURL := HostedMenderAPIURL
+	HostedMenderAPIURL = "http://hosted.mender.invalid"
+	defer func() {
+		HostedMenderAPIURL = oldHostedMenderAPIURL
+	}()
+
+	opts := &setupOptionsType{
+		username: "user@example.com",
+		password: "secret",
+		proxy:    proxy.URL,
+	}
+	require.NoError(t, opts.validateRequestFlags())
+	validEmailRegex := regexp.MustCompile(validEmailRegularExpression)
+	require.NoError(t, opts.tryLoginhostedMender(&stdinReader{}, validEmailRegex))
+	assert.Equal(t, "proxied-token", opts.tenantToken)
+	assert.Equal(t, []string{
+		"hosted.mender.invalid/api/management/v1/useradm/auth/login",
+		"hosted.mender.invalid/api/management/v1/tenantadm/user/tenant",
+	}, proxied)
+
+	for _, invalid := range []string{"proxy.example.com:3128", "ftp://proxy"} {
+		opts = &setupOptionsType{proxy: invalid}
+		assert.Error(t, opts.validateRequestFlags(), invalid)
+	}
+}
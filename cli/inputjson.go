@@ -0,0 +1,253 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// setupInput is the JSON object read with --input-json, which describes
+// the whole setup, so that nothing is prompted for:
+//
+//	{
+//	  "device_type": "raspberrypi4",          required
+//	  "hosted_mender": false,                 Hosted Mender, needs tenant_token
+//	  "demo_server": false,                   the demo server
+//	  "servers": ["https://mender.example.com"],
+//	                                          required unless hosted_mender
+//	                                          or demo_server
+//	  "server_ip": "10.0.0.1",                demo server IP, by default
+//	                                          127.0.0.1 without servers
+//	  "server_certificate": "/etc/mender/server.crt",
+//	  "tenant_token": "...",
+//	  "demo_polling": false,
+//	  "polls": {"update": 1800, "inventory": 28800, "retry": 300}
+//	}
+//
+// The inventory poll may also be "disabled", as with --inventory-poll.
+// Unknown keys are rejected. Flags given on the command line win, also over
+// invalid values.
+type setupInput struct {
+	DeviceType        string   `json:"device_type"`
+	HostedMender      bool     `json:"hosted_mender"`
+	DemoServer        bool     `json:"demo_server"`
+	Servers           []string `json:"servers"`
+	ServerIP          string   `json:"server_ip"`
+	ServerCertificate string   `json:"server_certificate"`
+	TenantToken       string   `json:"tenant_token"`
+	DemoPolling       bool     `json:"demo_polling"`
+	Polls             struct {
+		Update    int                `json:"update"`
+		Inventory inputInventoryPoll `json:"inventory"`
+		Retry     int                `json:"retry"`
+	} `json:"polls"`
+}
+
+// inputInventoryPoll is the inventory poll interval in seconds, or
+// inventoryPollDisabled when given as "disabled".
+type inputInventoryPoll int
+
+func (poll *inputInventoryPoll) UnmarshalJSON(data []byte) error {
+	var keyword string
+	if err := json.Unmarshal(data, &keyword); err == nil {
+		if !strings.EqualFold(keyword, pollDisabledKeyword) {
+			return errors.Errorf("invalid inventory poll interval %q: "+
+				"must be a number of seconds or %q", keyword,
+				pollDisabledKeyword)
+		}
+		*poll = inventoryPollDisabled
+		return nil
+	}
+	var seconds int
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return errors.Errorf("invalid inventory poll interval %s: "+
+			"must be a number of seconds or %q", data, pollDisabledKeyword)
+	}
+	*poll = inputInventoryPoll(seconds)
+	return nil
+}
+
+// readSetupInput reads the setup input from inputPath, or from stdin for
+// "-".
+func readSetupInput(inputPath string) (*setupInput, error) {
+	var r io.Reader = os.Stdin
+	if inputPath != "-" {
+		file, err := os.Open(inputPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot read the input JSON")
+		}
+		defer file.Close()
+		r = file
+	}
+	input := &setupInput{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(input); err != nil {
+		return nil, errors.Wrap(err, "Invalid input JSON")
+	}
+	return input, nil
+}
+
+// validate checks the input the same way the prompts check the answers,
+// except for the values replaced by flags, which given reports.
+func (input *setupInput) validate(given func(flags ...string) bool) error {
+	if !given("device-type") {
+		if !regexp.MustCompile(validDeviceRegularExpression).
+			MatchString(input.DeviceType) {
+			return errors.Errorf("Invalid input JSON: invalid device_type %q",
+				input.DeviceType)
+		}
+		if err := checkPrintable(input.DeviceType); err != nil {
+			return errors.Wrap(err, "Invalid input JSON: invalid device_type")
+		}
+	}
+	if !given("hosted-mender", "demo-server", "server-url", "server-ip") {
+		switch {
+		case input.HostedMender && input.DemoServer:
+			return errors.New("Invalid input JSON: hosted_mender and " +
+				"demo_server cannot both be set")
+		case input.HostedMender && input.TenantToken == "" &&
+			!given("tenant-token", "tenant-token-file"):
+			return errors.New("Invalid input JSON: hosted_mender requires " +
+				"tenant_token")
+		case !input.HostedMender && !input.DemoServer && len(input.Servers) == 0:
+			return errors.New("Invalid input JSON: servers is required " +
+				"unless hosted_mender or demo_server is set")
+		}
+	}
+	if !given("server-url") {
+		for _, serverURL := range input.Servers {
+			if !isValidServerURL(serverURL) {
+				return errors.Errorf("Invalid input JSON: invalid server URL %q",
+					serverURL)
+			}
+		}
+	}
+	if input.ServerIP != "" && !given("server-ip") {
+		if _, _, ok := parseServerIP(input.ServerIP); !ok {
+			return errors.Errorf("Invalid input JSON: invalid server_ip %q",
+				input.ServerIP)
+		}
+	}
+	if input.ServerCertificate != "" && !given("server-cert") {
+		if _, err := os.Stat(input.ServerCertificate); err != nil {
+			return errors.Wrap(err,
+				"Invalid input JSON: invalid server_certificate")
+		}
+	}
+	for _, poll := range []struct {
+		name  string
+		flag  string
+		value int
+	}{
+		{"update", "update-poll", input.Polls.Update},
+		{"inventory", "inventory-poll", int(input.Polls.Inventory)},
+		{"retry", "retry-poll", input.Polls.Retry},
+	} {
+		if given(poll.flag) {
+			continue
+		}
+		if poll.value != 0 && poll.value < minimumPollInterval {
+			return errors.Errorf("Invalid input JSON: the %s poll interval "+
+				"is %d; the minimum is %d seconds", poll.name, poll.value,
+				minimumPollInterval)
+		}
+	}
+	return nil
+}
+
+// flags returns the flag values equivalent to the input.
+func (input *setupInput) flags() map[string]string {
+	flags := map[string]string{
+		"device-type":   input.DeviceType,
+		"hosted-mender": strconv.FormatBool(input.HostedMender),
+		"demo-server":   strconv.FormatBool(input.DemoServer),
+		"server-cert":   input.ServerCertificate,
+		"demo-polling":  strconv.FormatBool(input.DemoPolling),
+	}
+	if len(input.Servers) > 0 {
+		flags["server-url"] = strings.Join(input.Servers, ",")
+	}
+	if input.ServerIP != "" {
+		flags["server-ip"] = input.ServerIP
+	} else if input.DemoServer && len(input.Servers) == 0 {
+		flags["server-ip"] = defaultServerIP
+	}
+	if input.TenantToken != "" {
+		flags["tenant-token"] = input.TenantToken
+	}
+	if !input.DemoPolling {
+		for _, poll := range []struct {
+			flag         string
+			value        int
+			defaultValue int
+		}{
+			{"update-poll", input.Polls.Update, defaultUpdatePoll},
+			{"inventory-poll", int(input.Polls.Inventory), defaultInventoryPoll},
+			{"retry-poll", input.Polls.Retry, defaultRetryPoll},
+		} {
+			if poll.value == 0 {
+				poll.value = poll.defaultValue
+			}
+			flags[poll.flag] = strconv.Itoa(poll.value)
+		}
+	}
+	return flags
+}
+
+// applyInputJSON sets the flags from the JSON object given with
+// --input-json, and turns prompting off.
+func (opts *setupOptionsType) applyInputJSON(ctx *cli.Context) error {
+	if opts.inputJSON == "" {
+		return nil
+	}
+	if opts.answersJSON != "" {
+		return errors.Errorf(errMsgConflictingArgumentsF,
+			"input-json", "answers-json")
+	}
+	input, err := readSetupInput(opts.inputJSON)
+	if err != nil {
+		return err
+	}
+	given := func(flags ...string) bool {
+		for _, flag := range flags {
+			if ctx.IsSet(flag) {
+				return true
+			}
+		}
+		return false
+	}
+	if err := input.validate(given); err != nil {
+		return err
+	}
+	for flag, value := range input.flags() {
+		// The command line wins, and a server URL rules out a server IP.
+		if given(flag) || (flag == "server-ip" && given("server-url")) {
+			continue
+		}
+		if err := ctx.Set(flag, value); err != nil {
+			return errors.Wrapf(err, "Cannot apply the input JSON to --%s",
+				flag)
+		}
+	}
+	return ctx.Set("non-interactive", "true")
+}
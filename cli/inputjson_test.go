@@ -0,0 +1,136 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupInputJSON(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	confPath := path.Join(tmpDir, "mender.conf")
+	defer log.SetLevel(log.GetLevel())
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	setupWithInput := func(input string, args ...string) error {
+		stdinR, stdinW, err := os.Pipe()
+		require.NoError(t, err)
+		os.Stdin = stdinR
+		stdinW.WriteString(input)
+		stdinW.Close()
+		return SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--config", confPath, "--data", tmpDir,
+			"--input-json", "-"}, args...))
+	}
+
+	require.NoError(t, setupWithInput(`{
+		"device_type": "acme-pi",
+		"servers": ["https://acme.mender.io", "https://backup.mender.io"],
+		"polls": {"update": 600, "retry": 60}
+	}`))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	var config conf.MenderConfigFromFile
+	require.NoError(t, json.Unmarshal(data, &config))
+	require.Len(t, config.Servers, 2)
+	assert.Equal(t, "https://acme.mender.io", config.Servers[0].ServerURL)
+	assert.Equal(t, "https://backup.mender.io", config.Servers[1].ServerURL)
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, defaultInventoryPoll, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, 60, config.RetryPollIntervalSeconds)
+	devType, err := ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=acme-pi\n", string(devType))
+
+	// The command line wins.
+	require.NoError(t, setupWithInput(`{
+		"device_type": "acme-pi",
+		"hosted_mender": true,
+		"tenant_token": "dummy-token"
+	}`, "--device-type", "other-pi"))
+	data, err = ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, hostedMenderURL, config.Servers[0].ServerURL)
+	assert.Equal(t, "dummy-token", config.TenantToken)
+	devType, err = ioutil.ReadFile(path.Join(tmpDir, "device_type"))
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=other-pi\n", string(devType))
+
+	// The inventory poll can be disabled, and flags replace invalid values.
+	require.NoError(t, setupWithInput(`{
+		"device_type": "acme pi",
+		"servers": ["https://acme.mender.io"],
+		"polls": {"update": 1, "inventory": "disabled"}
+	}`, "--device-type", "acme-pi", "--update-poll", "600"))
+	data, err = ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, inventoryPollDisabled, config.InventoryPollIntervalSeconds)
+
+	for _, test := range []struct {
+		input string
+		err   string
+	}{
+		{`{"device_type": "acme-pi", "server": "https://acme.mender.io"}`,
+			`unknown field "server"`},
+		{`{"device_type": "acme pi", "demo_server": true}`,
+			`invalid device_type "acme pi"`},
+		{`{"device_type": "acme-pi", "hosted_mender": true}`,
+			"hosted_mender requires tenant_token"},
+		{`{"device_type": "acme-pi"}`, "servers is required"},
+		{`{"device_type": "acme-pi", "servers": ["acme.mender.io"]}`,
+			`invalid server URL "acme.mender.io"`},
+		{`{"device_type": "acme-pi", "demo_server": true, "server_ip": "x"}`,
+			`invalid server_ip "x"`},
+		{`{"device_type": "acme-pi", "demo_server": true,
+		   "server_certificate": "/nonexistent/server.crt"}`,
+			"invalid server_certificate"},
+		{`{"device_type": "acme-pi", "demo_server": true,
+		   "polls": {"update": 1}}`, "the update poll interval is 1"},
+		{`{"device_type": "acme-pi", "demo_server": true,
+		   "polls": {"inventory": "never"}}`,
+			`invalid inventory poll interval "never"`},
+	} {
+		err := setupWithInput(test.input)
+		assert.ErrorContains(t, err, test.err, test.input)
+	}
+
+	err = SetupCLI([]string{"mender-setup", "--quiet", "--config", confPath,
+		"--data", tmpDir, "--input-json", "-", "--answers-json", "-"})
+	assert.ErrorContains(t, err, "answers-json")
+}
+
+func TestSetupInputFlags(t *testing.T) {
+	// The demo server IP defaults as when prompted.
+	input := &setupInput{DeviceType: "acme-pi", DemoServer: true}
+	assert.Equal(t, defaultServerIP, input.flags()["server-ip"])
+
+	input.Servers = []string{"https://docker.mender.io"}
+	assert.NotContains(t, input.flags(), "server-ip")
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+//go:build !unix || aix
+// +build !unix aix
+
+package cli
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// setupLock stands in for the setup lock, which is only taken where
+// flock(2) is available.
+type setupLock struct{}
+
+func acquireSetupLock(dir string) (*setupLock, error) {
+	log.Debug("Setup lock is not supported on this platform")
+	return nil, nil
+}
+
+func (l *setupLock) release() {}
@@ -0,0 +1,65 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+//go:build unix && !aix
+// +build unix,!aix
+
+package cli
+
+import (
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const setupLockFileName = "mender-setup.lock"
+
+// setupLock is an exclusive advisory lock held for the duration of a setup
+// run, so that concurrent runs do not interleave their writes.
+type setupLock struct {
+	f *os.File
+}
+
+// acquireSetupLock takes the setup lock in dir without blocking. If another
+// run already holds it, an error is returned immediately.
+func acquireSetupLock(dir string) (*setupLock, error) {
+	lockPath := path.Join(dir, setupLockFileName)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot open lock file %q", lockPath)
+	}
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		f.Close()
+		return nil, errors.Errorf("Another mender-setup run is in "+
+			"progress (lock %q is held); use --no-lock to override",
+			lockPath)
+	} else if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "Cannot lock %q", lockPath)
+	}
+	log.Debug("Acquired setup lock: ", lockPath)
+	return &setupLock{f: f}, nil
+}
+
+func (l *setupLock) release() {
+	if l == nil {
+		return
+	}
+	_ = unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	l.f.Close()
+}
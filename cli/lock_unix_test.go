@@ -0,0 +1,63 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+//go:build unix && !aix
+// +build unix,!aix
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSetupLock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "setuplock")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	lock, err := acquireSetupLock(tmpDir)
+	require.NoError(t, err)
+
+	_, err = acquireSetupLock(tmpDir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Another mender-setup run is in progress")
+
+	// A second run must fail before doing any setup.
+	flagSet := newFlagSet()
+	ctx := cli.NewContext(&cli.App{}, flagSet, nil)
+	ctx.Set("quiet", "true")
+	confPath := path.Join(tmpDir, "mender.conf")
+	runOptions := &runOptionsType{
+		config:       confPath,
+		dataStore:    tmpDir,
+		setupOptions: setupOptionsType{configPath: confPath},
+	}
+	err = runOptions.handleCLIOptions(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--no-lock")
+	_, err = os.Stat(confPath)
+	assert.True(t, os.IsNotExist(err))
+
+	lock.release()
+	lock, err = acquireSetupLock(tmpDir)
+	assert.NoError(t, err)
+	lock.release()
+}
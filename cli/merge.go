@@ -0,0 +1,107 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// keepExistingSettings takes the answers which were not given with flags
+// from the existing configuration, for --merge, so that setup neither asks
+// for nor changes them. These are the fields setup otherwise always writes:
+//
+//   - Servers, unless --server-url, --hosted-mender or --demo-server is
+//     given; the servers are kept as they are, including per-server
+//     settings.
+//   - ServerCertificate, unless --server-cert, --hosted-mender or
+//     --demo-server is given.
+//   - UpdatePollIntervalSeconds, InventoryPollIntervalSeconds and
+//     RetryPollIntervalSeconds, each unless given with its flag or with
+//     --demo-polling, and if at least minimumPollInterval.
+//
+// The other fields setup writes, such as TenantToken or MinTLSVersion, are
+// only changed when given, with or without --merge. Fields setup does not
+// know about, such as HttpsClient, are always kept.
+func (opts *setupOptionsType) keepExistingSettings(ctx *cli.Context,
+	config *conf.MenderConfigFromFile) {
+	if !opts.merge {
+		return
+	}
+	given := func(flags ...string) bool {
+		for _, flag := range flags {
+			if ctx.IsSet(flag) {
+				return true
+			}
+		}
+		return false
+	}
+	serverChoice := given("server-url", "hosted-mender", "demo-server")
+	if !serverChoice && len(config.Servers) > 0 {
+		urls := []string{}
+		for _, server := range config.Servers {
+			urls = append(urls, server.ServerURL)
+		}
+		_ = ctx.Set("server-url", strings.Join(urls, ","))
+		opts.serverURL = urls[0]
+		opts.fallbackServers = urls[1:]
+		opts.hostedMender = urls[0] == hostedMenderURL
+		_ = ctx.Set("hosted-mender", strconv.FormatBool(opts.hostedMender))
+		opts.demoServer = false
+		_ = ctx.Set("demo-server", "false")
+		if opts.hostedMender && !given("tenant-token") {
+			token := config.TenantToken
+			if token == "" {
+				token = config.Servers[0].TenantToken
+			}
+			if token != "" {
+				opts.tenantToken = token
+				_ = ctx.Set("tenant-token", token)
+			}
+		}
+		opts.keepServers = true
+	}
+	if !serverChoice && !given("server-cert") {
+		opts.serverCert = config.ServerCertificate
+		_ = ctx.Set("server-cert", config.ServerCertificate)
+	}
+	if given("demo-polling") {
+		return
+	}
+	kept := false
+	for _, poll := range []struct {
+		flag     string
+		existing int
+		interval *int
+	}{
+		{"update-poll", config.UpdatePollIntervalSeconds, &opts.updatePollInterval},
+		{"inventory-poll", config.InventoryPollIntervalSeconds, &opts.invPollInterval},
+		{"retry-poll", config.RetryPollIntervalSeconds, &opts.retryPollInterval},
+	} {
+		if given(poll.flag) || poll.existing < minimumPollInterval {
+			continue
+		}
+		*poll.interval = poll.existing
+		_ = ctx.Set(poll.flag, strconv.Itoa(poll.existing))
+		kept = true
+	}
+	if kept {
+		opts.demoIntervals = false
+		_ = ctx.Set("demo-polling", "false")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupMerge(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, _, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.merge = true
+	opts.nonInteractive = true
+
+	require.NoError(t, ioutil.WriteFile(opts.configPath, []byte(`{
+  "Servers": [
+    {"ServerURL": "https://primary.example.com", "TenantToken": "primary-token"},
+    {"ServerURL": "https://secondary.example.com"}
+  ],
+  "ServerCertificate": "/etc/mender/server.crt",
+  "UpdatePollIntervalSeconds": 600,
+  "InventoryPollIntervalSeconds": 3600,
+  "RetryPollIntervalSeconds": 120,
+  "HttpsClient": {"Certificate": "/etc/mender/client.crt"}
+}`), 0600))
+	loaded, err := conf.LoadConfig(opts.configPath, "")
+	require.NoError(t, err)
+	config := &loaded.MenderConfigFromFile
+	config.DeviceTypeFile = path.Join(path.Dir(opts.configPath), "device_type")
+
+	// Only the device type and the update poll interval are given.
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("update-poll", "1200")
+	opts.updatePollInterval = 1200
+	require.NoError(t, doSetup(ctx, config, opts))
+
+	written, err := conf.LoadConfig(opts.configPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, []conf.MenderServer{
+		{ServerURL: "https://primary.example.com", TenantToken: "primary-token"},
+		{ServerURL: "https://secondary.example.com"},
+	}, written.Servers)
+	assert.Equal(t, "/etc/mender/server.crt", written.ServerCertificate)
+	assert.Equal(t, 1200, written.UpdatePollIntervalSeconds)
+	assert.Equal(t, 3600, written.InventoryPollIntervalSeconds)
+	assert.Equal(t, 120, written.RetryPollIntervalSeconds)
+	assert.Equal(t, "/etc/mender/client.crt", written.HttpsClient.Certificate)
+}
+
+func TestSetupMergeServerGiven(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, _, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.merge = true
+	opts.nonInteractive = true
+
+	config := &conf.MenderConfigFromFile{
+		Servers:                   []conf.MenderServer{{ServerURL: "https://old.example.com"}},
+		ServerCertificate:         "/etc/mender/old.crt",
+		UpdatePollIntervalSeconds: 600,
+		DeviceTypeFile: path.Join(
+			path.Dir(opts.configPath), "device_type"),
+	}
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("server-url", "https://new.example.com")
+	opts.serverURL = "https://new.example.com"
+	ctx.Set("server-cert", "")
+	opts.serverCert = ""
+	ctx.Set("inventory-poll", "3600")
+	opts.invPollInterval = 3600
+	ctx.Set("retry-poll", "120")
+	opts.retryPollInterval = 120
+	require.NoError(t, doSetup(ctx, config, opts))
+
+	assert.Equal(t, []conf.MenderServer{{ServerURL: "https://new.example.com"}},
+		config.Servers)
+	assert.Equal(t, "", config.ServerCertificate)
+	assert.Equal(t, 600, config.UpdatePollIntervalSeconds)
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// needed so that we can override it when testing
+var DefaultPolicyFile = "/etc/mender/setup-policy.json"
+
+// setupPolicy is a system-wide policy which sets defaults for, and forbids
+// some choices of, setup runs on a device, e.g.:
+//
+//	{
+//	    "Defaults": {"ServerURL": "https://mender.example.com"},
+//	    "ForbidDemo": true,
+//	    "RequireHTTPS": true
+//	}
+type setupPolicy struct {
+	path string
+
+	// Defaults are used as if given as flags, unless those flags are.
+	Defaults struct {
+		DeviceType                   string
+		ServerURL                    string
+		UpdatePollIntervalSeconds    int
+		InventoryPollIntervalSeconds int
+		RetryPollIntervalSeconds     int
+	}
+	// ForbidSkipVerify rejects configurations which skip verifying the
+	// server certificate.
+	ForbidSkipVerify bool
+	// ForbidDemo rejects the demo server and demo polling intervals.
+	ForbidDemo bool
+	// RequireHTTPS rejects server URLs which are not https.
+	RequireHTTPS bool
+}
+
+// loadSetupPolicy reads the policy at path. It is not an error for it not
+// to exist, in which case nil is returned.
+func loadSetupPolicy(path string) (*setupPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read setup policy %q", path)
+	}
+	policy := &setupPolicy{path: path}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing setup policy %q", path)
+	}
+	log.Infof("Loaded setup policy %s", path)
+	return policy, nil
+}
+
+// applyDefaults sets the flags which were not given from the policy
+// defaults.
+func (p *setupPolicy) applyDefaults(ctx *cli.Context) {
+	if p == nil {
+		return
+	}
+	for _, def := range []struct {
+		flag  string
+		value string
+	}{
+		{"device-type", p.Defaults.DeviceType},
+		{"server-url", p.Defaults.ServerURL},
+		{"update-poll", intDefault(p.Defaults.UpdatePollIntervalSeconds)},
+		{"inventory-poll", intDefault(p.Defaults.InventoryPollIntervalSeconds)},
+		{"retry-poll", intDefault(p.Defaults.RetryPollIntervalSeconds)},
+	} {
+		if def.value != "" && !ctx.IsSet(def.flag) {
+			_ = ctx.Set(def.flag, def.value)
+		}
+	}
+}
+
+func intDefault(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+// checkOptions rejects the choices made with flags or prompts which the
+// policy forbids.
+func (p *setupPolicy) checkOptions(opts *setupOptionsType) error {
+	if p == nil {
+		return nil
+	}
+	if p.ForbidDemo && (opts.demoServer || opts.demoIntervals) {
+		return errors.Errorf("The demo server and demo polling intervals "+
+			"are forbidden by the setup policy %s", p.path)
+	}
+	if p.RequireHTTPS && !opts.hostedMender {
+		for _, serverURL := range append(
+			[]string{opts.serverURL}, opts.fallbackServers...) {
+			if !isHTTPS(serverURL) {
+				return errors.Errorf("The server URL %s is not https, "+
+					"which is required by the setup policy %s",
+					serverURL, p.path)
+			}
+		}
+	}
+	return nil
+}
+
+// checkConfig rejects configurations which the policy forbids.
+func (p *setupPolicy) checkConfig(config *conf.MenderConfigFromFile) error {
+	if p == nil {
+		return nil
+	}
+	if p.ForbidSkipVerify && config.SkipVerify {
+		return errors.Errorf("Skipping the server certificate verification "+
+			"is forbidden by the setup policy %s", p.path)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestPolicy writes content as a setup policy in a new temporary
+// directory, which the caller must remove, and loads it.
+func writeTestPolicy(t *testing.T, content string) *setupPolicy {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	policyPath := path.Join(tmpDir, "setup-policy.json")
+	require.NoError(t, ioutil.WriteFile(policyPath, []byte(content), 0600))
+	policy, err := loadSetupPolicy(policyPath)
+	require.NoError(t, err)
+	return policy
+}
+
+func TestSetupPolicyForbidsDemo(t *testing.T) {
+	policy := writeTestPolicy(t, `{"ForbidDemo": true}`)
+	defer os.RemoveAll(path.Dir(policy.path))
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.policy = policy
+
+	ctx.Set("device-type", "dev-pi")
+	opts.deviceType = "dev-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "true")
+	opts.demoServer = true
+	opts.serverIP = "127.0.0.1"
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+
+	err := doSetup(ctx, config, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "forbidden by the setup policy")
+	_, err = os.Stat(opts.configPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSetupPolicyDefaultsAndChecks(t *testing.T) {
+	policy := writeTestPolicy(t, `{
+		"Defaults": {
+			"ServerURL": "http://mender.example.com",
+			"UpdatePollIntervalSeconds": 600
+		},
+		"ForbidSkipVerify": true,
+		"RequireHTTPS": true
+	}`)
+	defer os.RemoveAll(path.Dir(policy.path))
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.policy = policy
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("retry-poll", "60")
+	ctx.Set("inventory-poll", "3600")
+	policy.applyDefaults(ctx)
+	require.NoError(t, opts.handleImplicitFlags(ctx))
+	assert.Equal(t, "http://mender.example.com", ctx.String("server-url"))
+	assert.Equal(t, 600, opts.updatePollInterval)
+	assert.Equal(t, 60, opts.retryPollInterval)
+
+	// The default server URL itself violates RequireHTTPS
+	opts.serverURL = ctx.String("server-url")
+	ctx.Set("server-cert", "")
+	opts.allowInsecureHTTP = true
+	err := doSetup(ctx, config, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "required by the setup policy")
+
+	ctx.Set("server-url", "https://mender.example.com")
+	opts.serverURL = "https://mender.example.com"
+	config.SkipVerify = true
+	err = doSetup(ctx, config, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Skipping the server certificate")
+
+	config.SkipVerify = false
+	assert.NoError(t, doSetup(ctx, config, opts))
+
+	// So do fallback servers
+	opts.fallbackServers = []string{"http://fallback.example.com"}
+	assert.ErrorContains(t, policy.checkOptions(opts),
+		"http://fallback.example.com is not https")
+
+	// A missing policy is no policy
+	missing, err := loadSetupPolicy(path.Join(path.Dir(policy.path), "none"))
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
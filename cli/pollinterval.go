@@ -0,0 +1,76 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// pollDisabledKeyword turns the inventory poll off.
+	pollDisabledKeyword = "disabled"
+	// inventoryPollDisabled is written as InventoryPollIntervalSeconds for
+	// --inventory-poll disabled. The client has no setting to turn inventory
+	// reporting off, so the largest 32 bit interval is used: about 68 years,
+	// so inventory is only sent once, when the client starts.
+	inventoryPollDisabled = math.MaxInt32
+)
+
+// parseInventoryPoll parses an inventory poll interval in seconds, or the
+// keyword "disabled".
+func parseInventoryPoll(value string) (int, error) {
+	if strings.EqualFold(strings.TrimSpace(value), pollDisabledKeyword) {
+		return inventoryPollDisabled, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// inventoryPollFlag is the value of --inventory-poll, which also accepts
+// "disabled". It is still read with ctx.Int.
+type inventoryPollFlag struct {
+	seconds *int
+}
+
+func (f *inventoryPollFlag) Set(value string) error {
+	seconds, err := parseInventoryPoll(value)
+	if err != nil {
+		return errors.Errorf("Invalid inventory poll interval %q: must be "+
+			"a number of seconds or %q", value, pollDisabledKeyword)
+	}
+	*f.seconds = seconds
+	return nil
+}
+
+func (f *inventoryPollFlag) String() string {
+	if f.seconds == nil {
+		return ""
+	}
+	return strconv.Itoa(*f.seconds)
+}
+
+// describePollIntervals returns the poll intervals as shown to the operator,
+// such as "update 1800s, inventory 28800s, retry 300s".
+func describePollIntervals(update, inventory, retry int) string {
+	inventoryPoll := strconv.Itoa(inventory) + "s"
+	if inventory == inventoryPollDisabled {
+		inventoryPoll = pollDisabledKeyword
+	}
+	return fmt.Sprintf("update %ds, inventory %s, retry %ds",
+		update, inventoryPoll, retry)
+}
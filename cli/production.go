@@ -0,0 +1,63 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultProductionMarkerFile marks a production device by its presence;
+// needed so that we can override it when testing.
+var DefaultProductionMarkerFile = "/etc/mender/production"
+
+// isProductionDevice tells whether the device is marked as a production one,
+// either with the marker file or with a true "production" entry in the
+// device manifest, and where the mark was found.
+func isProductionDevice(ctx *cli.Context) (bool, string) {
+	if _, err := os.Stat(DefaultProductionMarkerFile); err == nil {
+		return true, DefaultProductionMarkerFile
+	}
+	manifest := path.Join(ctx.String("data"), "device_type")
+	value, err := GetManifestData("production", manifest)
+	if err != nil || value == "" {
+		return false, ""
+	}
+	production, err := strconv.ParseBool(value)
+	return err == nil && production, manifest
+}
+
+// checkProductionDevice refuses the demo server and the demo poll intervals
+// on a production device, unless --force-demo is given, as they would
+// point a device in the field to a test server or flood the real one.
+func (opts *setupOptionsType) checkProductionDevice(ctx *cli.Context) error {
+	if opts.forceDemo || opts.hostedMender ||
+		!(opts.demoServer || opts.demoIntervals) {
+		return nil
+	}
+	production, marker := isProductionDevice(ctx)
+	if !production {
+		return nil
+	}
+	setting := "the demo server"
+	if !opts.demoServer {
+		setting = "the demo poll intervals"
+	}
+	return errors.Errorf("Refusing to set up %s on a production device "+
+		"(marked in %s); use --force-demo to do so anyway", setting, marker)
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupProductionDevice(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	defer log.SetLevel(log.GetLevel())
+
+	oldDefaultProductionMarkerFile := DefaultProductionMarkerFile
+	DefaultProductionMarkerFile = path.Join(tdir, "production")
+	defer func() {
+		DefaultProductionMarkerFile = oldDefaultProductionMarkerFile
+	}()
+	oldDefaultHostsFile := DefaultHostsFile
+	DefaultHostsFile = path.Join(tdir, "hosts")
+	defer func() {
+		DefaultHostsFile = oldDefaultHostsFile
+	}()
+	require.NoError(t, ioutil.WriteFile(DefaultHostsFile,
+		[]byte("127.0.0.1 localhost\n"), 0644))
+	oldDefaultLocalTrustMenderDir := DefaultLocalTrustMenderDir
+	DefaultLocalTrustMenderDir = path.Join(tdir, "trust")
+	defer func() {
+		DefaultLocalTrustMenderDir = oldDefaultLocalTrustMenderDir
+	}()
+	oldDefaultMenderDemoCertDir := DefaultMenderDemoCertDir
+	DefaultMenderDemoCertDir = path.Join("..", "support")
+	defer func() {
+		DefaultMenderDemoCertDir = oldDefaultMenderDemoCertDir
+	}()
+
+	confPath := path.Join(tdir, "mender.conf")
+	dataDir := path.Join(tdir, "data")
+	require.NoError(t, os.Mkdir(dataDir, 0755))
+	setup := func(args ...string) error {
+		return SetupCLI(append([]string{"mender-setup", "--quiet",
+			"--config", confPath, "--data", dataDir,
+			"--device-type", "dev-pi"}, args...))
+	}
+	demo := []string{"--demo-server", "--server-ip", "127.0.0.1",
+		"--demo-polling"}
+
+	// Not marked.
+	require.NoError(t, setup(demo...))
+
+	// Marked with the file...
+	require.NoError(t, os.Remove(confPath))
+	require.NoError(t, ioutil.WriteFile(DefaultProductionMarkerFile, nil, 0644))
+	err = setup(demo...)
+	assert.ErrorContains(t, err, "Refusing to set up the demo server on a "+
+		"production device")
+	assert.ErrorContains(t, err, DefaultProductionMarkerFile)
+	_, err = os.Stat(confPath)
+	assert.True(t, os.IsNotExist(err))
+	err = setup("--server-url", "https://mender.example.com",
+		"--server-cert", "", "--demo-polling")
+	assert.ErrorContains(t, err, "the demo poll intervals")
+	require.NoError(t, setup("--server-url", "https://mender.example.com",
+		"--server-cert", "", "--update-poll", "1800",
+		"--inventory-poll", "28800", "--retry-poll", "300"))
+
+	// ...or in the manifest.
+	require.NoError(t, os.Remove(DefaultProductionMarkerFile))
+	require.NoError(t, ioutil.WriteFile(path.Join(dataDir, "device_type"),
+		[]byte("device_type=dev-pi\nproduction=true\n"), 0644))
+	err = setup(demo...)
+	assert.ErrorContains(t, err, path.Join(dataDir, "device_type"))
+
+	require.NoError(t, setup(append(demo, "--force-demo")...))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), defaultServerURL)
+}
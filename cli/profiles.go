@@ -0,0 +1,107 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+// tuningProfile is a curated set of poll intervals and connectivity
+// settings, selected with --profile.
+type tuningProfile struct {
+	updatePoll    int
+	inventoryPoll int
+	retryPoll     int
+	connectivity  conf.Connectivity
+}
+
+// tuningProfiles are the profiles available with --profile. Poll intervals
+// given explicitly with --update-poll, --inventory-poll or --retry-poll
+// take precedence over those of the profile.
+var tuningProfiles = map[string]tuningProfile{
+	// lowbandwidth: update poll 2h, inventory poll 24h, retry poll 10min,
+	// and no persistent connections.
+	"lowbandwidth": {
+		updatePoll:    7200,
+		inventoryPoll: 86400,
+		retryPoll:     600,
+		connectivity: conf.Connectivity{
+			DisableKeepAlive: true,
+		},
+	},
+	// balanced: the regular defaults, update poll 30min, inventory poll
+	// 8h, retry poll 5min, and the client's own keep-alive handling.
+	"balanced": {
+		updatePoll:    defaultUpdatePoll,
+		inventoryPoll: defaultInventoryPoll,
+		retryPoll:     defaultRetryPoll,
+	},
+	// aggressive: update poll 1min, inventory poll 10min, retry poll 30s,
+	// with idle connections kept open for 5min.
+	"aggressive": {
+		updatePoll:    60,
+		inventoryPoll: 600,
+		retryPoll:     30,
+		connectivity: conf.Connectivity{
+			IdleConnTimeoutSeconds: 300,
+		},
+	},
+}
+
+func tuningProfileNames() string {
+	names := make([]string, 0, len(tuningProfiles))
+	for name := range tuningProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyProfile sets the poll intervals which were not given explicitly from
+// the profile chosen with --profile, as if they had been given as flags.
+func (opts *setupOptionsType) applyProfile(ctx *cli.Context) error {
+	if opts.profile == "" {
+		return nil
+	}
+	profile, ok := tuningProfiles[opts.profile]
+	if !ok {
+		return errors.Errorf("Invalid profile %q: must be one of %s",
+			opts.profile, tuningProfileNames())
+	}
+	if ctx.IsSet("demo-polling") || ctx.IsSet("demo") {
+		return errors.Errorf(errMsgConflictingArgumentsF,
+			"profile", "demo-polling")
+	}
+	for _, poll := range []struct {
+		flag  string
+		value int
+	}{
+		{"update-poll", profile.updatePoll},
+		{"inventory-poll", profile.inventoryPoll},
+		{"retry-poll", profile.retryPoll},
+	} {
+		if !ctx.IsSet(poll.flag) {
+			_ = ctx.Set(poll.flag, strconv.Itoa(poll.value))
+		}
+	}
+	opts.connectivity = profile.connectivity
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-setup/conf"
+)
+
+func TestSetupProfiles(t *testing.T) {
+	testCases := map[string]struct {
+		update       int
+		inventory    int
+		retry        int
+		connectivity conf.Connectivity
+	}{
+		"lowbandwidth": {
+			update:       7200,
+			inventory:    86400,
+			retry:        600,
+			connectivity: conf.Connectivity{DisableKeepAlive: true},
+		},
+		"balanced": {
+			update:    defaultUpdatePoll,
+			inventory: defaultInventoryPoll,
+			retry:     defaultRetryPoll,
+		},
+		"aggressive": {
+			update:       60,
+			inventory:    600,
+			retry:        30,
+			connectivity: conf.Connectivity{IdleConnTimeoutSeconds: 300},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			flagSet := newFlagSet()
+			ctx, config, runOptions := initCLITest(t, flagSet)
+			defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+			opts := &runOptions.setupOptions
+
+			ctx.Set("device-type", "acme-pi")
+			opts.deviceType = "acme-pi"
+			ctx.Set("hosted-mender", "true")
+			opts.hostedMender = true
+			ctx.Set("tenant-token", "dummy-token")
+			opts.tenantToken = "dummy-token"
+			opts.profile = name
+
+			require.NoError(t, opts.handleImplicitFlags(ctx))
+			require.NoError(t, doSetup(ctx, config, opts))
+			assert.Equal(t, tc.update, config.UpdatePollIntervalSeconds)
+			assert.Equal(t, tc.inventory, config.InventoryPollIntervalSeconds)
+			assert.Equal(t, tc.retry, config.RetryPollIntervalSeconds)
+			assert.Equal(t, tc.connectivity, config.Connectivity)
+		})
+	}
+}
+
+func TestSetupProfileOverrides(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "true")
+	opts.hostedMender = true
+	ctx.Set("tenant-token", "dummy-token")
+	opts.tenantToken = "dummy-token"
+	ctx.Set("update-poll", "900")
+	opts.profile = "lowbandwidth"
+
+	require.NoError(t, opts.handleImplicitFlags(ctx))
+	require.NoError(t, doSetup(ctx, config, opts))
+	assert.Equal(t, 900, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, 86400, config.InventoryPollIntervalSeconds)
+	assert.True(t, config.Connectivity.DisableKeepAlive)
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, _, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+
+	opts.profile = "turbo"
+	err := opts.applyProfile(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "aggressive, balanced, lowbandwidth")
+
+	opts.profile = "balanced"
+	ctx.Set("demo-polling", "true")
+	err = opts.applyProfile(ctx)
+	assert.Error(t, err)
+}
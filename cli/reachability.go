@@ -0,0 +1,135 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCheckTimeout = 10 // seconds
+
+// checkTimeoutDuration returns the --check-timeout for the reachability and
+// discovery requests, which is separate from the login timeout.
+func (opts *setupOptionsType) checkTimeoutDuration() time.Duration {
+	seconds := opts.checkTimeout
+	if seconds == 0 {
+		seconds = defaultCheckTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// serverAddress returns the host:port to connect to for serverURL. In demo
+// mode the server IP replaces the host, as /etc/hosts would do for the
+// client.
+func serverAddress(u *url.URL, serverIP string) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	if serverIP == "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	ip, ipPort, ok := parseServerIP(serverIP)
+	if !ok {
+		ip = serverIP
+	}
+	if ipPort != "" {
+		port = ipPort
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// checkDemoServerTLS connects to the demo server at the configured IP and
+// performs a TLS handshake using the server URL host as SNI, verified
+// against the demo certificate, the same way the client will.
+func (opts *setupOptionsType) checkDemoServerTLS() error {
+	u, err := url.Parse(opts.serverURL)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid server URL %q", opts.serverURL)
+	}
+	if u.Scheme != "https" {
+		log.Debugf("Not checking TLS for %s", opts.serverURL)
+		return nil
+	}
+
+	certPath := opts.demoCertPath()
+	pem, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot read demo certificate %q", certPath)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return errors.Errorf("No certificates found in %q", certPath)
+	}
+
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return err
+	}
+	tlsConfig.ServerName = u.Hostname()
+	tlsConfig.RootCAs = roots
+
+	addr := serverAddress(u, opts.serverIP)
+	// The timeout covers the TLS handshake too.
+	dialer := &net.Dialer{Timeout: opts.checkTimeoutDuration()}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	if err != nil {
+		var hostErr x509.HostnameError
+		if errors.As(err, &hostErr) {
+			return errors.Errorf("The demo server at %s presented a "+
+				"certificate for %s, which does not match the server URL "+
+				"host %q. The demo certificate is only valid for its own "+
+				"host name; keep the server URL host unchanged in demo mode "+
+				"or use a certificate for %q",
+				addr, certificateNames(hostErr.Certificate),
+				u.Hostname(), u.Hostname())
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return errors.Errorf("The demo server at %s did not complete "+
+				"a TLS handshake within %s; raise --check-timeout on a "+
+				"slow network", addr, opts.checkTimeoutDuration())
+		}
+		return errors.Wrapf(err, "Cannot establish a TLS connection to "+
+			"the demo server at %s", addr)
+	}
+	conn.Close()
+	log.Infof("Verified TLS connection to %s (%s)", u.Hostname(), addr)
+	return nil
+}
+
+// certificateNames lists the names a certificate is valid for.
+func certificateNames(cert *x509.Certificate) string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestServerAsDemoCert points the demo certificate at the certificate of
+// srv, which httptest issues for "example.com" and 127.0.0.1.
+func useTestServerAsDemoCert(t *testing.T, srv *httptest.Server) func() {
+	tdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	})
+	err = ioutil.WriteFile(path.Join(tdir, "demo.crt"), certPEM, 0644)
+	require.NoError(t, err)
+
+	oldDefaultMenderDemoCertDir := DefaultMenderDemoCertDir
+	DefaultMenderDemoCertDir = tdir
+	return func() {
+		DefaultMenderDemoCertDir = oldDefaultMenderDemoCertDir
+		os.RemoveAll(tdir)
+	}
+}
+
+func TestCheckDemoServerTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	defer useTestServerAsDemoCert(t, srv)()
+
+	serverIP := srv.Listener.Addr().String()
+
+	// SNI matching the certificate
+	opts := &setupOptionsType{
+		serverURL: "https://example.com",
+		serverIP:  serverIP,
+	}
+	assert.NoError(t, opts.checkDemoServerTLS())
+
+	// The operator changed the host, but kept the demo certificate
+	opts.serverURL = "https://docker.mender.io"
+	err := opts.checkDemoServerTLS()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate for example.com")
+	assert.Contains(t, err.Error(), `server URL host "docker.mender.io"`)
+
+	// The server does not offer the --min-tls-version; httptest servers
+	// share their certificate.
+	tls12Srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	tls12Srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	tls12Srv.StartTLS()
+	defer tls12Srv.Close()
+	opts.serverURL = "https://example.com"
+	opts.serverIP = tls12Srv.Listener.Addr().String()
+	assert.NoError(t, opts.checkDemoServerTLS())
+	opts.minTLSVersion = "1.3"
+	err = opts.checkDemoServerTLS()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cannot establish a TLS connection")
+	opts.minTLSVersion = ""
+	opts.serverIP = serverIP
+
+	// Nothing listening
+	opts.serverURL = "https://example.com"
+	opts.serverIP = "127.0.0.1:1"
+	err = opts.checkDemoServerTLS()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cannot establish a TLS connection")
+}
+
+func TestCheckDemoServerTLSTimeout(t *testing.T) {
+	// Accepts connections, but never answers the TLS handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	defer useTestServerAsDemoCert(t, srv)()
+
+	opts := &setupOptionsType{
+		serverURL:    "https://example.com",
+		serverIP:     listener.Addr().String(),
+		checkTimeout: 1,
+	}
+	start := time.Now()
+	err = opts.checkDemoServerTLS()
+	elapsed := time.Since(start)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not complete a TLS handshake within 1s")
+	assert.GreaterOrEqual(t, elapsed, time.Second)
+	assert.Less(t, elapsed, time.Duration(defaultCheckTimeout)*time.Second)
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// recommendedPollsPath is the Hosted Mender endpoint with the poll intervals
+// recommended for the plan of the tenant.
+const recommendedPollsPath = "/api/management/v1/tenantadm/user/tenant/poll_intervals"
+
+type recommendedPollsResponse struct {
+	UpdatePoll    int `json:"update_poll_interval_seconds"`
+	InventoryPoll int `json:"inventory_poll_interval_seconds"`
+	RetryPoll     int `json:"retry_poll_interval_seconds"`
+}
+
+// applyRecommendedPolls uses the poll intervals recommended by Hosted Mender
+// for those not given with flags. Without them, e.g. when offline, setup
+// goes on as without --use-server-recommended-polls.
+func (opts *setupOptionsType) applyRecommendedPolls(ctx *cli.Context,
+	userToken []byte) {
+	recommended, err := opts.fetchRecommendedPolls(userToken)
+	if err != nil {
+		opts.warnings.warnf("Not using the recommended poll intervals: %v", err)
+		return
+	}
+	applied := false
+	for _, poll := range []struct {
+		flag     string
+		value    int
+		interval *int
+	}{
+		{"update-poll", recommended.UpdatePoll, &opts.updatePollInterval},
+		{"inventory-poll", recommended.InventoryPoll, &opts.invPollInterval},
+		{"retry-poll", recommended.RetryPoll, &opts.retryPollInterval},
+	} {
+		if ctx.IsSet(poll.flag) || poll.value < minimumPollInterval {
+			continue
+		}
+		*poll.interval = poll.value
+		_ = ctx.Set(poll.flag, strconv.Itoa(poll.value))
+		applied = true
+	}
+	if !applied {
+		return
+	}
+	if !ctx.IsSet("demo-polling") {
+		// The demo intervals would replace the recommended ones.
+		opts.demoIntervals = false
+		_ = ctx.Set("demo-polling", "false")
+	}
+	log.Infof("Using the recommended poll intervals: update %ds, "+
+		"inventory %ds, retry %ds", opts.updatePollInterval,
+		opts.invPollInterval, opts.retryPollInterval)
+}
+
+func (opts *setupOptionsType) fetchRecommendedPolls(
+	userToken []byte) (*recommendedPollsResponse, error) {
+	client, err := opts.newLoginClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET",
+		HostedMenderAPIURL+recommendedPollsPath, nil)
+	if err != nil {
+		return nil, errors.Wrap(err,
+			"Error creating recommended poll intervals request")
+	}
+	req.Header.Set("Authorization", "Bearer "+string(userToken))
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, opts.loginRequestError(err,
+			"Recommended poll intervals request FAILED")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Recommended poll intervals request "+
+			"FAILED: unexpected statuscode %d", rsp.StatusCode)
+	}
+	recommended := &recommendedPollsResponse{}
+	if err := json.NewDecoder(rsp.Body).Decode(recommended); err != nil {
+		return nil, errors.Wrap(err, "Error parsing JSON response.")
+	}
+	return recommended, nil
+}
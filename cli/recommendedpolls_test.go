@@ -0,0 +1,92 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupRecommendedPolls(t *testing.T) {
+	recommended := true
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/management/v1/useradm/auth/login":
+				w.Write([]byte("user-token"))
+			case "/api/management/v1/tenantadm/user/tenant":
+				w.Write([]byte(`{"tenant_token": "tenant-token"}`))
+			case recommendedPollsPath:
+				if !recommended ||
+					r.Header.Get("Authorization") != "Bearer user-token" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(`{
+					"update_poll_interval_seconds": 3600,
+					"inventory_poll_interval_seconds": 86400,
+					"retry_poll_interval_seconds": 120
+				}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer srv.Close()
+	defer withHostedMenderAPI(srv)()
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.useServerPolls = true
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "true")
+	opts.hostedMender = true
+	ctx.Set("username", "user@example.com")
+	opts.username = "user@example.com"
+	ctx.Set("password", "secret")
+	opts.password = "secret"
+	// An explicit interval overrides the recommended one.
+	ctx.Set("update-poll", "1200")
+	opts.updatePollInterval = 1200
+	require.NoError(t, doSetup(ctx, config, opts))
+
+	assert.Equal(t, "tenant-token", config.TenantToken)
+	assert.Equal(t, 1200, config.UpdatePollIntervalSeconds)
+	assert.Equal(t, 86400, config.InventoryPollIntervalSeconds)
+	assert.Equal(t, 120, config.RetryPollIntervalSeconds)
+
+	// Without recommendations, setup goes on with the intervals it has.
+	recommended = false
+	opts = &setupOptionsType{
+		updatePollInterval: defaultUpdatePoll,
+		invPollInterval:    defaultInventoryPoll,
+		retryPollInterval:  defaultRetryPoll,
+	}
+	ctx, _, runOptions = initCLITest(t, newFlagSet())
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts.applyRecommendedPolls(ctx, []byte("user-token"))
+	assert.Equal(t, defaultUpdatePoll, opts.updatePollInterval)
+	assert.Equal(t, defaultInventoryPoll, opts.invPollInterval)
+	assert.Equal(t, defaultRetryPoll, opts.retryPollInterval)
+	assert.False(t, ctx.IsSet("demo-polling"))
+}
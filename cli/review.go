@@ -0,0 +1,160 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	promptReviewSettings = "\nReview the settings:"
+	promptReviewEdit     = "Enter a number to change that setting, or " +
+		"press Enter to write the configuration: "
+
+	rspInvalidReviewChoice = "Please enter a number between 1 and %d, " +
+		"or press Enter to write the configuration: "
+)
+
+// reviewSettings lists the settings which were prompted for, so that the
+// operator can change any of them before the configuration is written. A
+// changed setting is asked again on its own, unless the answer leads to
+// other questions than before, such as choosing Hosted Mender instead of
+// an own server; then the questions from there on are asked again. The
+// review is skipped when confirmations are.
+func (opts *setupOptionsType) reviewSettings(ctx *cli.Context,
+	stdin *stdinReader, history []int, next map[int]int) error {
+	if len(history) == 0 || opts.skipConfirmations(ctx) {
+		return nil
+	}
+	for {
+		fmt.Println(promptReviewSettings)
+		for i, state := range history {
+			label, value := opts.reviewSetting(state)
+			fmt.Printf("  %d) %s: %s\n", i+1, label, value)
+		}
+		i, err := stdin.promptReviewChoice(len(history))
+		if err == errGoBack {
+			continue
+		} else if err != nil {
+			return err
+		} else if i < 0 {
+			return nil
+		}
+		state := history[i]
+		nextState, err := opts.askState(ctx, stdin, state)
+		if err == errGoBack {
+			continue
+		} else if err != nil {
+			return err
+		}
+		// stateFeatures always leads to the device type, but the
+		// selected features change the questions after it.
+		if nextState == next[state] && state != stateFeatures {
+			continue
+		}
+		next[state] = nextState
+		history, err = opts.askStates(ctx, stdin, nextState,
+			history[:i+1], next)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// promptReviewChoice returns the index of the setting chosen to change, or
+// -1 when the settings were confirmed.
+func (stdin *stdinReader) promptReviewChoice(settings int) (int, error) {
+	rsp, err := stdin.promptUser(promptReviewEdit, false)
+	for err == nil {
+		rsp = strings.TrimSpace(rsp)
+		if rsp == "" {
+			return -1, nil
+		}
+		if n, convErr := strconv.Atoi(rsp); convErr == nil &&
+			n >= 1 && n <= settings {
+			return n - 1, nil
+		}
+		rsp, err = stdin.promptUser(
+			fmt.Sprintf(rspInvalidReviewChoice, settings), false)
+	}
+	return -1, err
+}
+
+// reviewSetting returns the name and the current value of the setting asked
+// for in state.
+func (opts *setupOptionsType) reviewSetting(state int) (string, string) {
+	switch state {
+	case stateDeviceType:
+		return "Device type", opts.deviceType
+	case stateHostedMender:
+		return "Hosted Mender", yesNo(opts.hostedMender)
+	case stateDemoServer:
+		return "Demo server", yesNo(opts.demoServer)
+	case stateServerURL:
+		servers := append([]string{opts.serverURL}, opts.fallbackServers...)
+		return "Server URL", strings.Join(servers, ", ")
+	case stateServerIP:
+		return "Server IP", opts.serverIP
+	case stateServerCert:
+		if opts.serverCert == "" {
+			return "Server certificate", "system certificate store"
+		}
+		return "Server certificate", opts.serverCert
+	case stateClientCert, stateMTLS:
+		if opts.clientCert == "" {
+			return "Client certificate", "none"
+		}
+		return "Client certificate", opts.clientCert
+	case stateCredentials:
+		return "Hosted Mender credentials", "given"
+	case statePolling:
+		return "Poll intervals", describePollIntervals(
+			opts.updatePollInterval, opts.invPollInterval,
+			opts.retryPollInterval)
+	case stateFeatures:
+		features := []string{}
+		for _, feature := range optionalFeatures {
+			if opts.features[feature.name] {
+				features = append(features, feature.name)
+			}
+		}
+		if len(features) == 0 {
+			return "Optional areas", "none"
+		}
+		return "Optional areas", strings.Join(features, ", ")
+	case stateConnectivity:
+		return "Connectivity", fmt.Sprintf("keep-alive disabled: %s, "+
+			"idle connection timeout: %ds",
+			yesNo(opts.connectivity.DisableKeepAlive),
+			opts.connectivity.IdleConnTimeoutSeconds)
+	case stateStateScripts:
+		return "State script timeouts", fmt.Sprintf("timeout %ds, "+
+			"retry timeout %ds, retry interval %ds", opts.stateScriptTimeout,
+			opts.stateScriptRetryTimeout, opts.stateScriptRetryInterval)
+	case stateArtifactKeys:
+		return "Artifact verification keys", strings.Join(opts.artifactKeys, ", ")
+	}
+	return "", ""
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupReviewSettings(t *testing.T) {
+	stdin := os.Stdin
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { os.Stdin = stdin }()
+	os.Stdin = stdinR
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+
+	ctx.Set("quiet", "false")
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "https://acme.mender.io")
+	opts.serverURL = "https://acme.mender.io"
+	ctx.Set("server-cert", "")
+
+	stdinW.WriteString("first-pi\n")  // Device type
+	stdinW.WriteString("7\n")         // Review: out of range
+	stdinW.WriteString("1\n")         // Review: change the device type
+	stdinW.WriteString("second-pi\n") // Device type
+	stdinW.WriteString("\n")          // Review: confirm
+	require.NoError(t, doSetup(ctx, config, opts))
+	devType, err := ioutil.ReadFile(config.DeviceTypeFile)
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=second-pi\n", string(devType))
+
+	// With --assume-yes the configuration is written without a review.
+	opts.assumeYes = true
+	stdinW.WriteString("third-pi\n") // Device type
+	stdinW.Close()
+	require.NoError(t, doSetup(ctx, config, opts))
+	devType, err = ioutil.ReadFile(config.DeviceTypeFile)
+	require.NoError(t, err)
+	assert.Equal(t, "device_type=third-pi\n", string(devType))
+}
+
+func TestReviewSetting(t *testing.T) {
+	opts := &setupOptionsType{
+		serverURL:          "https://primary.example.com",
+		fallbackServers:    []string{"https://secondary.example.com"},
+		updatePollInterval: 1800,
+		invPollInterval:    28800,
+		retryPollInterval:  300,
+		features:           map[string]bool{featureArtifactKeys: true},
+	}
+	for _, tc := range []struct {
+		state int
+		label string
+		value string
+	}{
+		{stateHostedMender, "Hosted Mender", "no"},
+		{stateServerURL, "Server URL",
+			"https://primary.example.com, https://secondary.example.com"},
+		{stateServerCert, "Server certificate", "system certificate store"},
+		{statePolling, "Poll intervals",
+			"update 1800s, inventory 28800s, retry 300s"},
+		{stateFeatures, "Optional areas", featureArtifactKeys},
+	} {
+		label, value := opts.reviewSetting(tc.state)
+		assert.Equal(t, tc.label, label)
+		assert.Equal(t, tc.value, value)
+	}
+}
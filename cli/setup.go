@@ -16,16 +16,26 @@ package cli
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 
@@ -41,17 +51,99 @@ type setupOptionsType struct {
 	deviceType         string
 	username           string
 	password           string
+	accessToken        string
 	serverURL          string
 	serverIP           string
 	serverCert         string
 	tenantToken        string
 	invPollInterval    int
 	retryPollInterval  int
+	retryPollCount     int
+	retryPollCountSet  bool // given or answered, else kept as it is
+	disableKeepAlive   bool
+	idleConnTimeout    int // seconds, 0 for the client default
 	updatePollInterval int
 	hostedMender       bool
 	demo               bool // deprecated
 	demoServer         bool
 	demoIntervals      bool
+	deviceTypeArtifact string
+	hostsUpdateMode    string
+	hostsFile          string // overrides DefaultHostsFile
+	noHosts            bool   // also when adding the route was declined
+	forceDemo          bool   // demo settings on a production device
+	serverConfigStyle  string
+	keepServerURL      bool
+	verifyClient       bool
+	selectFeatures     bool
+	certOverridesDemo  bool
+	certExpiryWarnDays int // 0 for defaultCertExpiryWarnDays
+	assumeYes          bool
+	experimental       bool
+	pollJitter         int          // percent, requires --experimental
+	policy             *setupPolicy // nil without a policy file
+	fallbackServers    []string     // after serverURL, in order
+	sanitizeHostname   bool
+	discoveryURL       string
+	configURL          string // with configURLDeviceType
+	checkRateLimits    bool
+	writeChecksum      bool
+	envFile            string // --write-env-file
+	dryRun             bool   // log the writes instead of making them
+	noBackup           bool
+	preserveACLs       bool
+	sortedKeys         bool
+	nonInteractive     bool
+	normalizeDevType   bool
+	toStdout           bool
+	bootstrapArtifact  string
+	tenantTokenFile    string
+	loginTimeout       int // seconds, for the Hosted Mender requests
+	useServerPolls     bool
+	explain            bool // print the rationale of each answer
+	merge              bool
+	prefix             string // install prefix of the default paths
+	keepServers        bool   // with --merge, leave config.Servers alone
+	hostedUserToken    []byte // authorizes requests after the login
+	discoveredCert     []byte // PEM from the discovery endpoint
+	forceServerCert    bool   // replace a different discovered-cert file
+	skipDemoTrust      bool   // the demo cert fingerprint was declined
+	// Optional areas chosen with --select-features, and their settings
+	features                 map[string]bool
+	clientCert               string
+	clientKey                string
+	sslEngine                string
+	stateScriptTimeout       int
+	stateScriptRetryTimeout  int
+	stateScriptRetryInterval int
+	artifactKeys             []string // also --artifact-verify-keys
+	artifactVerifyKey        string
+	configFormat             string
+	answersJSON              string
+	inputJSON                string
+	fetchedConfig            *conf.MenderConfigFromFile
+	demoCert                 string // overrides getMenderDemoCertPath
+	skipCARefresh            bool
+	trustStyle               string
+	auditLog                 string
+	promptInput              io.Reader // what follows the answers on stdin
+	deviceTypeLineEnding     string
+	fixExtension             bool
+	strict                   bool
+	minTLSVersion            string
+	clientCABundle           string // trust for setup's own requests
+	clientCADirs             []string
+	proxy                    string // for setup's own requests
+	daemonLogLevel           string
+	updateLogPath            string
+	checkReachability        bool
+	checkTimeout             int // seconds, for reachability and discovery
+	allowInsecureHTTP        bool
+	noDemoClamp              bool // keep explicit poll intervals in demo mode
+	profile                  string
+	connectivity             conf.Connectivity // from the profile
+	timings                  *phaseTimings     // nil unless --timings is given
+	warnings                 *warningSink      // nil unless --warnings-summary is given
 }
 
 type logOptionsType struct {
@@ -66,8 +158,14 @@ const ( // state enum
 	stateServerURL
 	stateServerIP
 	stateServerCert
+	stateClientCert
 	stateCredentials
 	statePolling
+	stateFeatures
+	stateMTLS
+	stateConnectivity
+	stateStateScripts
+	stateArtifactKeys
 	stateDone
 	stateInvalid = -1
 )
@@ -76,21 +174,62 @@ var (
 	// needed so that we can override it when testing
 	DefaultMenderDemoCertDir      = "/usr/share/doc/mender-auth/examples"
 	DefaultLocalTrustMenderDir    = "/usr/local/share/ca-certificates/mender"
+	DefaultRedhatLocalTrustDir    = "/etc/pki/ca-trust/source/anchors"
 	DefaultLocalTrustMenderPrefix = "mender-demo-"
 	DefaultLocalTrustMenderFormat = "mender-demo-%d.crt"
+	DefaultHostsFile              = "/etc/hosts"
+	DefaultHostnameFile           = "/etc/hostname"
+	DefaultOSReleaseFile          = "/etc/os-release"
+	// Refreshes the system certificate store from the local trust.
+	updateCACertificatesBinary = "update-ca-certificates"
+	updateCATrustBinary        = "update-ca-trust"
+	// Base URL for the Hosted Mender API requests made during setup.
+	HostedMenderAPIURL = hostedMenderURL
+)
+
+// Modes for adding the demo server route to the hosts file.
+const (
+	hostsUpdateAppend  = "append"
+	hostsUpdateReplace = "replace"
+	hostsUpdateSkip    = "skip"
+)
+
+// Terminators of the line in the device_type file.
+const (
+	lineEndingLF   = "lf"
+	lineEndingCRLF = "crlf"
+	lineEndingNone = "none"
+)
+
+// Styles for where the server certificate and tenant token are written.
+const (
+	serverConfigTopLevel  = "top-level"  // the legacy style, for all clients
+	serverConfigPerServer = "per-server" // inside each Servers entry
 )
 
 func getMenderDemoCertPath() string {
 	return path.Join(DefaultMenderDemoCertDir, "demo.crt")
 }
 
+// demoCertPath returns the demo certificate given with --demo-cert, or else
+// the one installed with the Mender client.
+func (opts *setupOptionsType) demoCertPath() string {
+	if opts.demoCert != "" {
+		return opts.demoCert
+	}
+	return getMenderDemoCertPath()
+}
+
 const (
 	// Constraint constants
-	minimumPollInterval          = 5
+	minimumPollInterval = 5
+	// Polls shorter than this are typically rate limited by Hosted Mender
+	rateLimitedPollInterval      = 60
 	validDeviceRegularExpression = "^[A-Za-z0-9-_]+$"
-	validURLRegularExpression    = `(http|https):\/\/(\w+:{0,1}\w*@)?` +
-		`(\S+)(:[0-9]+)?((\/\S+?\/)*)(\/|\/([\w#!:.?+=&%@!\-\/]))?`
-	validIPRegularExpression = `^([0-9]{1,3}\.){3}[0-9]{1,3}(:[0-9]{1,5})?$`
+	// An IPv4 address, or an IPv6 address, bracketed if with a port.
+	validIPRegularExpression = `^(([0-9]{1,3}\.){3}[0-9]{1,3}(:[0-9]{1,5})?|` +
+		`[0-9A-Fa-f:.]*:[0-9A-Fa-f:.]*|` +
+		`\[[0-9A-Fa-f:.]*:[0-9A-Fa-f:.]*\](:[0-9]{1,5})?)$`
 	// RFC5322 email regex
 	validEmailRegularExpression = `(?:[a-z0-9!#$%&'*+/=?^_` + "`" +
 		`{|}~-]+(?:\.[a-z0-9!#$%&'*+/=?^_` + "`" +
@@ -114,6 +253,12 @@ const (
 	demoControlMapExpiration     = 90
 	demoControlMapBootExpiration = 45
 	hostedMenderURL              = "https://hosted.mender.io"
+	configureLogging             = "logging" // --configure area
+	defaultLoginTimeout          = 30        // seconds
+
+	// Environment variables with the Hosted Mender credentials
+	envUsername = "MENDER_SETUP_USERNAME"
+	envPassword = "MENDER_SETUP_PASSWORD"
 
 	// Prompt constants
 	promptWizard = "Mender Client Setup\n" +
@@ -121,7 +266,8 @@ const (
 		"Setting up the Mender client: The client will " +
 		"regularly poll the server to check for updates and report " +
 		"its inventory data.\nGet started by first configuring the " +
-		"device type and settings for communicating with the server."
+		"device type and settings for communicating with the server.\n" +
+		"Enter " + backToken + " at any prompt to return to the previous question."
 	promptDone       = "Mender setup successfully."
 	promptDeviceType = "\nThe device type property is used to determine " +
 		"which Mender Artifact are compatible with this device.\n" +
@@ -153,12 +299,18 @@ const (
 		"the client may attempt more often initially based on the " +
 		"previous intervals, but will fall back to this value if the" +
 		"server is busy) [300]" // (defaultRetryPoll)
+	promptRetryPollCount = "Set the maximum number of retry polls before " +
+		"the client gives up on a request, or 0 for unlimited: [0]"
 	promptInventoryPoll = "Set the inventory poll interval - the " +
 		"frequency with which the client will send inventory data to " +
-		"the server, in seconds: [28800]" // (defaultInventoryPoll)
+		"the server, in seconds, or \"disabled\": [28800]" // (defaultInventoryPoll)
 	// Response on invalid input
 	rspInvalidDevice = "The device type \"%s\" contains spaces or special " +
 		"characters.\nPlease try again: [%s]"
+	rspUnprintableDevice = "The device type contains %s, possibly " +
+		"pasted from a rich text source.\nPlease try again: [%s]"
+	rspUnprintableURL = "The server URL contains %s, possibly pasted " +
+		"from a rich text source.\nPlease enter a valid url for the server: "
 	rspSelectYN     = "Please select Y or N: "
 	rspInvalidEmail = "\n\"%s\" does not appear to be a " + // NOTE: format
 		"valid email address.\nPlease enter a valid email address: "
@@ -167,14 +319,36 @@ const (
 	rspConnectionError = "There was a problem connecting to " +
 		hostedMenderURL + ". \nPlease check your device’s " +
 		"connection and try again."
+	errMsgLoginTimeoutF = "%s: no response from " + hostedMenderURL +
+		" within %d seconds; retry, or raise --login-timeout " +
+		"on a slow connection"
 	rspNotSeconds = "The value you entered wasn’t an integer number.\n" +
 		"Please enter a number (in seconds): "
+	rspInvalidCount = "The value you entered isn’t a non-negative " +
+		"integer number.\nPlease try again: [0]"
 	rspInvalidInterval = "Polling interval too short.\nPlease enter a " +
 		"value of minimum 5 seconds: " // (minimumPollInterval)
 	rspInvalidURL = "Please enter a valid url for the server: "
 	rspInvalidIP  = "Please enter a valid IP address: "
 	// NOTE: format
 	rspFileNotExist = "The file '%s' does not exist.\nPlease try again: "
+
+	rspNoPreviousQuestion = "There is no previous question to return to."
+
+	promptDemoCertFingerprints = "\nThe demo certificate %s will be " +
+		"installed in the local trust. Its SHA-256 fingerprints are:\n"
+	promptTrustDemoCert = "Do you want to trust the demo certificate? [Y/n] "
+	// NOTE: format
+	promptAddHostsRouteF = "Add '%s' to %s? [Y/n] "
+
+	promptRetryConfigWrite         = "\n%s\nFix the problem and retry, or abort.\n"
+	promptRetryAbort               = "Retry or Abort? [R/a] "
+	rspSelectRetryAbort            = "Please select R)etry or A)bort: "
+	promptAddFallbackServer        = "Add a fallback server? [y/N] "
+	promptAddAnotherFallbackServer = "Add another fallback server? [y/N] "
+	promptFallbackServerURL        = "Set the URL of the fallback server: "
+	promptServerList               = "\nThe client will try the servers in this order:"
+	promptConfirmServers           = "Do you want to write these servers? [Y/n] "
 )
 
 // ---------------------------- END Setup constants ----------------------------
@@ -239,27 +413,125 @@ func GetDeviceType(deviceTypeFile string) (string, error) {
 	return GetManifestData("device_type", deviceTypeFile)
 }
 
-func getDefaultDeviceType(ctx *cli.Context) (devType string) {
+// getDefaultDeviceType returns the current device type, or else the
+// VARIANT_ID of the operating system, or else the host name. With
+// sanitizeHostname, characters of the fallback which are not valid in a
+// device type are replaced by '-'.
+func getDefaultDeviceType(ctx *cli.Context, sanitizeHostname bool) (devType string) {
 	devType, err := GetDeviceType(path.
 		Join(ctx.String("data"), "device_type"))
-	if err != nil {
-		hostName, err := ioutil.ReadFile("/etc/hostname")
+	if err == nil {
+		return devType
+	}
+	devType, err = getOSReleaseField("VARIANT_ID", DefaultOSReleaseFile)
+	if err != nil || devType == "" {
+		hostName, err := ioutil.ReadFile(DefaultHostnameFile)
 		if err != nil {
 			return "unknown"
 		}
 		devType = string(hostName)
 		devType = strings.Trim(devType, "\n")
 	}
+	if sanitizeHostname {
+		devType = sanitizeDeviceType(devType)
+	}
 	return devType
 }
 
+// getOSReleaseField returns the value of field in the os-release file, with
+// its quotes removed, or "" if the field is not present.
+func getOSReleaseField(field, osReleaseFile string) (string, error) {
+	osRelease, err := os.Open(osReleaseFile)
+	if err != nil {
+		return "", err
+	}
+	defer osRelease.Close()
+
+	scanner := bufio.NewScanner(osRelease)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 || pair[0] != field {
+			continue
+		}
+		return unquoteOSReleaseValue(pair[1]), nil
+	}
+	return "", scanner.Err()
+}
+
+// unquoteOSReleaseValue removes the shell quotes around an os-release value,
+// e.g. "raspberrypi4" or 'raspberrypi4', and the backslash escapes within
+// double quotes.
+func unquoteOSReleaseValue(value string) string {
+	if len(value) < 2 || value[0] != value[len(value)-1] {
+		return value
+	}
+	switch value[0] {
+	case '\'':
+		return value[1 : len(value)-1]
+	case '"':
+		var unquoted strings.Builder
+		escaped := false
+		for _, r := range value[1 : len(value)-1] {
+			if r == '\\' && !escaped {
+				escaped = true
+				continue
+			}
+			escaped = false
+			unquoted.WriteRune(r)
+		}
+		return unquoted.String()
+	}
+	return value
+}
+
+var invalidDeviceTypeChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// sanitizeDeviceType makes name valid as a device type, e.g.
+// "build-01.example.com" becomes "build-01-example-com".
+func sanitizeDeviceType(name string) string {
+	sanitized := invalidDeviceTypeChars.ReplaceAllString(
+		strings.TrimSpace(name), "-")
+	if sanitized == "" {
+		return "unknown"
+	}
+	return sanitized
+}
+
+// backToken is entered at a prompt to return to the previous question.
+const backToken = "!back"
+
+// errGoBack is returned by prompts when the user entered backToken.
+var errGoBack = errors.New("go back to the previous question")
+
 type stdinReader struct {
 	reader *bufio.Reader
+	// Number of prompts shown, used to tell which states asked anything.
+	prompts int
+	// With --non-interactive, prompting is an error.
+	nonInteractive bool
+}
+
+// requireFlag returns an error naming the flag which must be given in
+// non-interactive mode, where its value can not be prompted for.
+func (stdin *stdinReader) requireFlag(flag string) error {
+	if stdin.nonInteractive {
+		return errors.Errorf("%s is required in non-interactive mode", flag)
+	}
+	return nil
 }
 
 func (stdin *stdinReader) promptUser(prompt string, disableEcho bool) (string, error) {
 	var rsp string
 	var err error
+	if stdin.nonInteractive {
+		return "", errors.Errorf("Cannot prompt %q in non-interactive mode",
+			strings.TrimSpace(prompt))
+	}
+	stdin.prompts++
 	fmt.Print(prompt)
 	if disableEcho {
 		pwd, err := terminal.ReadPassword(int(os.Stdin.Fd()))
@@ -275,6 +547,9 @@ func (stdin *stdinReader) promptUser(prompt string, disableEcho bool) (string, e
 	if err != nil {
 		return rsp, errors.Wrap(err, "Error reading from stdin.")
 	}
+	if !disableEcho && strings.TrimSpace(rsp) == backToken {
+		return "", errGoBack
+	}
 	return rsp, err
 }
 
@@ -306,26 +581,201 @@ func (stdin *stdinReader) promptYN(prompt string,
 	return ret, nil
 }
 
+// validateRequestFlags checks the flags of setup's own requests, before
+// the first of them, to the --discovery-url, is made.
+func (opts *setupOptionsType) validateRequestFlags() error {
+	if opts.checkTimeout < 0 {
+		return errors.Errorf("Invalid check timeout %d: must be a "+
+			"number of seconds", opts.checkTimeout)
+	}
+	if opts.proxy != "" {
+		if _, err := parseProxyURL(opts.proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFlags checks flag values which are not validated by the prompts.
+func (opts *setupOptionsType) validateFlags() error {
+	if opts.bootstrapArtifact != "" {
+		if err := checkBootstrapArtifact(opts.bootstrapArtifact); err != nil {
+			return err
+		}
+	}
+	if opts.nonInteractive && opts.selectFeatures {
+		return errors.Errorf(errMsgConflictingArgumentsF,
+			"non-interactive", "select-features")
+	}
+	if opts.preserveACLs && !xattrsSupported {
+		return errors.New("--preserve-acls is only supported on Linux")
+	}
+	if opts.toStdout {
+		// These need the written configuration file
+		for _, conflict := range []struct {
+			flag string
+			set  bool
+		}{
+			{"write-checksum", opts.writeChecksum},
+			{"verify-with-client", opts.verifyClient},
+			{"bootstrap-artifact", opts.bootstrapArtifact != ""},
+		} {
+			if conflict.set {
+				return errors.Errorf(errMsgConflictingArgumentsF,
+					"stdout", conflict.flag)
+			}
+		}
+	}
+	switch opts.hostsUpdateMode {
+	case "", hostsUpdateAppend, hostsUpdateReplace, hostsUpdateSkip:
+	default:
+		return errors.Errorf("Invalid hosts update mode %q: must be one "+
+			"of append, replace or skip", opts.hostsUpdateMode)
+	}
+	switch opts.trustStyle {
+	case "", trustStyleDebian, trustStyleRedhat:
+	default:
+		return errors.Errorf("Invalid trust style %q: must be one of "+
+			"debian or redhat", opts.trustStyle)
+	}
+	switch opts.deviceTypeLineEnding {
+	case "", lineEndingLF, lineEndingCRLF, lineEndingNone:
+	default:
+		return errors.Errorf("Invalid device type line ending %q: must be "+
+			"one of lf, crlf or none", opts.deviceTypeLineEnding)
+	}
+	if opts.minTLSVersion != "" {
+		if _, err := parseTLSVersion(opts.minTLSVersion); err != nil {
+			return err
+		}
+	}
+	if opts.loginTimeout < 0 {
+		return errors.Errorf("Invalid login timeout %d: must be a "+
+			"number of seconds", opts.loginTimeout)
+	}
+	if opts.daemonLogLevel != "" {
+		if _, err := log.ParseLevel(opts.daemonLogLevel); err != nil {
+			return errors.Errorf("Invalid daemon log level %q: must be "+
+				"one of panic, fatal, error, warning, info, debug or trace",
+				opts.daemonLogLevel)
+		}
+	}
+	if opts.retryPollCount < 0 {
+		return errors.Errorf("Invalid retry poll count %d: must be a "+
+			"non-negative integer, or 0 for unlimited", opts.retryPollCount)
+	}
+	if (opts.clientCert == "") != (opts.clientKey == "") {
+		return errors.New("--client-cert and --client-key must be " +
+			"given together")
+	}
+	if opts.sslEngine != "" && opts.clientCert == "" {
+		return errors.New("--ssl-engine requires --client-cert and " +
+			"--client-key")
+	}
+	if opts.clientCert != "" {
+		if _, err := os.Stat(opts.clientCert); err != nil {
+			return errors.Errorf("Client certificate %q does not exist",
+				opts.clientCert)
+		}
+	}
+	// With an SSL engine the key is an identifier within it, not a file.
+	if opts.clientKey != "" && opts.sslEngine == "" {
+		if _, err := os.Stat(opts.clientKey); err != nil {
+			return errors.Errorf("Client key %q does not exist",
+				opts.clientKey)
+		}
+	}
+	for _, key := range append([]string{opts.artifactVerifyKey},
+		opts.artifactKeys...) {
+		if key == "" {
+			continue
+		}
+		if _, err := os.Stat(key); err != nil {
+			return errors.Errorf("Artifact verification key %q does not "+
+				"exist", key)
+		}
+	}
+	if opts.certExpiryWarnDays < 0 {
+		return errors.Errorf("Invalid certificate expiry warning threshold "+
+			"%d: must be a positive number of days", opts.certExpiryWarnDays)
+	}
+	if opts.idleConnTimeout < 0 {
+		return errors.Errorf("Invalid idle connection timeout %d: must be "+
+			"a non-negative number of seconds", opts.idleConnTimeout)
+	}
+	if opts.pollJitter != 0 {
+		if !opts.experimental {
+			return errors.New("--poll-jitter requires --experimental, " +
+				"since the client does not read PollJitterPercent yet")
+		}
+		if opts.pollJitter < 0 || opts.pollJitter > 100 {
+			return errors.Errorf("Invalid poll jitter %d: must be a "+
+				"percentage between 0 and 100", opts.pollJitter)
+		}
+	}
+	if _, err := opts.clientCAPool(); err != nil {
+		return err
+	}
+	switch opts.serverConfigStyle {
+	case "", serverConfigTopLevel, serverConfigPerServer:
+	default:
+		return errors.Errorf("Invalid server config style %q: must be one "+
+			"of top-level or per-server", opts.serverConfigStyle)
+	}
+	return nil
+}
+
+// readTenantTokenFile sets the tenant token from the --tenant-token-file,
+// which keeps it out of the shell history and process listings.
+func (opts *setupOptionsType) readTenantTokenFile(ctx *cli.Context) error {
+	if opts.tenantTokenFile == "" {
+		return nil
+	}
+	if ctx.IsSet("tenant-token") {
+		return errors.Errorf(errMsgConflictingArgumentsF,
+			"tenant-token", "tenant-token-file")
+	}
+	data, err := ioutil.ReadFile(opts.tenantTokenFile)
+	if err != nil {
+		return errors.Wrap(err, "Cannot read the tenant token file")
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return errors.Errorf("The tenant token file %q is empty",
+			opts.tenantTokenFile)
+	}
+	opts.tenantToken = token
+	// Skips asking for the credentials
+	return ctx.Set("tenant-token", token)
+}
+
 // CLI functions for handling implicitly set flags.
 func (opts *setupOptionsType) handleImplicitFlags(ctx *cli.Context) error {
+	if err := opts.applyProfile(ctx); err != nil {
+		return err
+	}
 	if ctx.IsSet("demo") {
 		// deprecated, implies both --demo-server and --demo-polling
 		_ = ctx.Set("demo-server", "true")
 		_ = ctx.Set("demo-polling", "true")
 	}
 	if ctx.IsSet("update-poll") {
-		_ = ctx.Set("demo-polling", "false")
-		opts.demoIntervals = false
+		if !opts.noDemoClamp {
+			_ = ctx.Set("demo-polling", "false")
+			opts.demoIntervals = false
+		}
 		opts.updatePollInterval = ctx.Int("update-poll")
 	}
 	if ctx.IsSet("inventory-poll") {
-		_ = ctx.Set("demo-polling", "false")
-		opts.demoIntervals = false
+		if !opts.noDemoClamp {
+			_ = ctx.Set("demo-polling", "false")
+			opts.demoIntervals = false
+		}
 		opts.invPollInterval = ctx.Int("inventory-poll")
 	}
 	if ctx.IsSet("retry-poll") {
-		_ = ctx.Set("demo-polling", "false")
-		opts.demoIntervals = false
+		// Unlike the others, the retry interval is kept with demo
+		// polling, so it does not rule that out.
 		opts.retryPollInterval = ctx.Int("retry-poll")
 	}
 
@@ -343,6 +793,81 @@ func (opts *setupOptionsType) handleImplicitFlags(ctx *cli.Context) error {
 		_ = ctx.Set("hosted-mender", "false")
 		opts.hostedMender = false
 	}
+	if ctx.IsSet("artifact-verify-keys") {
+		opts.artifactKeys = ctx.StringSlice("artifact-verify-keys")
+		if err := conf.CheckArtifactVerifyKeys(
+			opts.artifactVerifyKey, opts.artifactKeys); err != nil {
+			return errors.Errorf(errMsgConflictingArgumentsF,
+				"artifact-verify-key", "artifact-verify-keys")
+		}
+	}
+	return nil
+}
+
+// resolveConfigDir treats a --config naming an existing directory as the
+// directory to write the default configuration file name into.
+func (opts *setupOptionsType) resolveConfigDir() {
+	info, err := os.Stat(opts.configPath)
+	if err != nil || !info.IsDir() {
+		return
+	}
+	configPath := path.Join(opts.configPath, path.Base(conf.DefaultConfFile))
+	log.Infof("%s is a directory, writing the configuration to %s",
+		opts.configPath, configPath)
+	opts.configPath = configPath
+}
+
+// checkConfigFormat settles the format to write the configuration in. If
+// none was given it follows the extension of the configuration file, and
+// otherwise the extension should agree with it.
+func (opts *setupOptionsType) checkConfigFormat() error {
+	extFormat := conf.FormatFromExtension(opts.configPath)
+	if opts.configFormat == "" {
+		opts.configFormat = extFormat
+		if opts.configFormat == "" {
+			opts.configFormat = conf.FormatJSON
+		}
+		return nil
+	}
+	if err := conf.ValidateFormat(opts.configFormat); err != nil {
+		return err
+	}
+	if extFormat == opts.configFormat {
+		return nil
+	}
+	if opts.fixExtension {
+		fixed := conf.FixExtension(opts.configPath, opts.configFormat)
+		log.Infof("Writing the %s configuration to %s",
+			opts.configFormat, fixed)
+		opts.configPath = fixed
+		return nil
+	}
+	if extFormat == "" {
+		return nil
+	}
+	msg := fmt.Sprintf("The configuration format %q does not match the "+
+		"extension of %q; use --fix-extension to correct it",
+		opts.configFormat, opts.configPath)
+	if opts.strict {
+		return errors.New(msg)
+	}
+	opts.warnings.warn(msg)
+	return nil
+}
+
+// applyDeviceTypeFromArtifact sets the device type from the Artifact given
+// with --device-type-from-artifact, so that it is not prompted for.
+func (opts *setupOptionsType) applyDeviceTypeFromArtifact(ctx *cli.Context) error {
+	if opts.deviceTypeArtifact == "" {
+		return nil
+	}
+	devType, err := deviceTypeFromArtifact(
+		opts.deviceTypeArtifact, ctx.String("device-type"))
+	if err != nil {
+		return err
+	}
+	_ = ctx.Set("device-type", devType)
+	opts.deviceType = devType
 	return nil
 }
 
@@ -395,22 +920,35 @@ func (opts *setupOptionsType) askCredentials(stdin *stdinReader,
 
 func (opts *setupOptionsType) askDeviceType(ctx *cli.Context,
 	stdin *stdinReader) (int, error) {
-	defaultDevType := getDefaultDeviceType(ctx)
+	defaultDevType := getDefaultDeviceType(ctx, opts.sanitizeHostname)
 	devTypePrompt := fmt.Sprintf(promptDeviceType, defaultDevType)
 	validDeviceRegex, err := regexp.Compile(validDeviceRegularExpression)
 	if err != nil {
 		return stateInvalid, errors.Wrap(err, "Unable to compile regex")
 	}
+	if opts.normalizeDevType && ctx.IsSet("device-type") {
+		opts.deviceType = opts.normalizeDeviceType(ctx.String("device-type"))
+		_ = ctx.Set("device-type", opts.deviceType)
+	}
 	if validDeviceRegex.Match([]byte(ctx.String("device-type"))) {
 		return stateHostedMender, nil
 	}
+	if err := stdin.requireFlag("device-type"); err != nil {
+		return stateInvalid, err
+	}
 	opts.deviceType, err = stdin.promptUser(devTypePrompt, false)
 	if err != nil {
 		return stateInvalid, err
 	}
 	for {
+		if opts.normalizeDevType {
+			opts.deviceType = opts.normalizeDeviceType(opts.deviceType)
+		}
 		if opts.deviceType == "" {
 			opts.deviceType = defaultDevType
+		} else if invalid := checkPrintable(opts.deviceType); invalid != nil {
+			rsp := fmt.Sprintf(rspUnprintableDevice, invalid, defaultDevType)
+			opts.deviceType, err = stdin.promptUser(rsp, false)
 		} else if !validDeviceRegex.Match([]byte(
 			opts.deviceType)) {
 			rsp := fmt.Sprintf(rspInvalidDevice, opts.deviceType,
@@ -426,11 +964,56 @@ func (opts *setupOptionsType) askDeviceType(ctx *cli.Context,
 	return stateHostedMender, nil
 }
 
+// deviceTypeFileData returns the content of the device_type file, with the
+// line terminated as given with --device-type-line-ending.
+func (opts *setupOptionsType) deviceTypeFileData() []byte {
+	line := "device_type=" + opts.deviceType
+	switch opts.deviceTypeLineEnding {
+	case lineEndingCRLF:
+		line += "\r\n"
+	case lineEndingNone:
+	default:
+		line += "\n"
+	}
+	return []byte(line)
+}
+
+// checkPrintable returns an error describing the first character in value
+// which is not printable, such as a zero-width space, or which is not valid
+// UTF-8.
+func checkPrintable(value string) error {
+	for i, r := range value {
+		if r == utf8.RuneError {
+			return errors.Errorf("invalid UTF-8 at byte %d", i)
+		}
+		if !unicode.IsPrint(r) {
+			return errors.Errorf("the invisible character %U at byte %d",
+				r, i)
+		}
+	}
+	return nil
+}
+
+// normalizeDeviceType lowercases and trims deviceType, since artifacts are
+// matched with the device type case sensitively, and warns if this changed
+// it.
+func (opts *setupOptionsType) normalizeDeviceType(deviceType string) string {
+	normalized := strings.ToLower(strings.TrimSpace(deviceType))
+	if normalized != deviceType {
+		opts.warnings.warnf("Normalized the device type %q to %q",
+			deviceType, normalized)
+	}
+	return normalized
+}
+
 func (opts *setupOptionsType) askHostedMender(ctx *cli.Context,
 	stdin *stdinReader) (int, error) {
 	var state int
 
 	if !ctx.IsSet("hosted-mender") {
+		if err := stdin.requireFlag("hosted-mender"); err != nil {
+			return stateInvalid, err
+		}
 		hostedMender, err := stdin.promptYN(
 			promptHostedMender, true)
 		if err != nil {
@@ -452,6 +1035,9 @@ func (opts *setupOptionsType) askDemoServer(ctx *cli.Context,
 	var state int
 
 	if !ctx.IsSet("demo-server") {
+		if err := stdin.requireFlag("demo-server"); err != nil {
+			return stateInvalid, err
+		}
 		demoServer, err := stdin.promptYN(promptDemoServer, true)
 		if err != nil {
 			return stateInvalid, err
@@ -476,14 +1062,24 @@ func (opts *setupOptionsType) askDemoServer(ctx *cli.Context,
 
 func (opts *setupOptionsType) askServerURL(ctx *cli.Context,
 	stdin *stdinReader) (int, error) {
-	validURLRegex, err := regexp.Compile(validURLRegularExpression)
-	if err != nil {
-		return stateInvalid, errors.Wrap(err, "Unable to compile regex")
-	}
-
+	var err error
 	if ctx.IsSet("server-url") {
-		opts.serverURL = ctx.String("server-url")
+		// A comma separated list gives fallback servers, in order
+		urls := strings.Split(ctx.String("server-url"), ",")
+		opts.serverURL = strings.TrimSpace(urls[0])
+		opts.fallbackServers = nil
+		for _, u := range urls[1:] {
+			u = strings.TrimSpace(u)
+			if !isValidServerURL(u) {
+				return stateInvalid, errors.Errorf(
+					"Invalid fallback server URL %q", u)
+			}
+			opts.fallbackServers = append(opts.fallbackServers, u)
+		}
 	} else {
+		if err := stdin.requireFlag("server-url"); err != nil {
+			return stateInvalid, err
+		}
 		opts.serverURL, err = stdin.promptUser(
 			promptServerURL, false)
 		if err != nil {
@@ -493,7 +1089,13 @@ func (opts *setupOptionsType) askServerURL(ctx *cli.Context,
 	for {
 		if opts.serverURL == "" {
 			opts.serverURL = defaultServerURL
-		} else if !validURLRegex.Match([]byte(opts.serverURL)) {
+		} else if invalid := checkPrintable(opts.serverURL); invalid != nil {
+			opts.serverURL, err = stdin.promptUser(
+				fmt.Sprintf(rspUnprintableURL, invalid), false)
+			if err != nil {
+				return stateInvalid, err
+			}
+		} else if !isValidServerURL(opts.serverURL) {
 			opts.serverURL, err = stdin.promptUser(
 				rspInvalidURL, false)
 			if err != nil {
@@ -503,105 +1105,464 @@ func (opts *setupOptionsType) askServerURL(ctx *cli.Context,
 			break
 		}
 	}
-	return stateServerCert, nil
-}
-
-func (opts *setupOptionsType) askServerIP(ctx *cli.Context,
-	stdin *stdinReader) (int, error) {
-	validIPRegex, err := regexp.Compile(validIPRegularExpression)
-	if err != nil {
-		return stateInvalid, errors.Wrap(err, "Unable to compile regex")
-	}
-
 	if !ctx.IsSet("server-url") {
-		// Set default server URL
-		// -- can be modified by flag.
-		opts.serverURL = defaultServerURL
+		if err := opts.askFallbackServer(stdin); err != nil {
+			return stateInvalid, err
+		}
 	}
-	if validIPRegex.Match([]byte(opts.serverIP)) {
-		// IP added by cmdline
+	if opts.allowInsecureHTTP && isPlainHTTP(opts.serverURL) {
+		// There is no certificate to ask for
 		return statePolling, nil
 	}
-	opts.serverIP, err = stdin.promptUser(
-		promptServerIP, false)
-	if err != nil {
-		return stateInvalid, err
-	}
+	return stateServerCert, nil
+}
+
+// askFallbackServer optionally asks for fallback servers, which the client
+// tries in order when the primary server is not reachable, until the user
+// declines to add another.
+func (opts *setupOptionsType) askFallbackServer(stdin *stdinReader) error {
+	opts.fallbackServers = nil
+	prompt := promptAddFallbackServer
 	for {
-		if opts.serverIP == "" {
-			// default
-			opts.serverIP = defaultServerIP
-			break
-		} else if !validIPRegex.Match([]byte(opts.serverIP)) {
-			opts.serverIP, err = stdin.promptUser(
-				rspInvalidIP, false)
+		addFallback, err := stdin.promptYN(prompt, false)
+		if err != nil || !addFallback {
+			return err
+		}
+		fallbackURL, err := stdin.promptUser(promptFallbackServerURL, false)
+		if err != nil {
+			return err
+		}
+		for !isValidServerURL(fallbackURL) {
+			fallbackURL, err = stdin.promptUser(rspInvalidURL, false)
 			if err != nil {
-				return stateInvalid, err
+				return err
 			}
-		} else {
-			break
 		}
+		opts.fallbackServers = append(opts.fallbackServers, fallbackURL)
+		prompt = promptAddAnotherFallbackServer
 	}
-	return statePolling, nil
 }
 
-func (opts *setupOptionsType) askServerCert(ctx *cli.Context,
-	stdin *stdinReader) (int, error) {
-	var err error
-	if ctx.IsSet("server-cert") {
-		return statePolling, nil
+// skipConfirmations returns true if confirmations should be skipped, and
+// their defaults used, with --assume-yes, --non-interactive or --quiet.
+func (opts *setupOptionsType) skipConfirmations(ctx *cli.Context) bool {
+	return opts.assumeYes || opts.nonInteractive || ctx.Bool("quiet")
+}
+
+// confirmServers shows the servers in the order the client tries them, and
+// asks for confirmation before writing them, when there are fallback
+// servers, unless confirmations are skipped.
+func (opts *setupOptionsType) confirmServers(ctx *cli.Context,
+	stdin *stdinReader) error {
+	if len(opts.fallbackServers) == 0 || opts.skipConfirmations(ctx) {
+		return nil
 	}
-	opts.serverCert, err = stdin.promptUser(
-		promptServerCert, false)
+	fmt.Println(promptServerList)
+	for i, serverURL := range append(
+		[]string{opts.serverURL}, opts.fallbackServers...) {
+		fmt.Printf("  %d) %s\n", i+1, serverURL)
+	}
+	confirmed, err := stdin.promptYN(promptConfirmServers, true)
 	if err != nil {
-		return stateInvalid, err
+		return err
 	}
-	for {
-		if opts.serverCert == "" {
-			// No certificates is allowed
-			break
-		} else if _, err = os.Stat(opts.serverCert); err != nil {
-			rsp := fmt.Sprintf(rspFileNotExist, opts.serverCert)
-			opts.serverCert, err = stdin.promptUser(
-				rsp, false)
-			if err != nil {
-				return stateInvalid, err
-			}
-		} else {
-			break
-		}
+	if !confirmed {
+		return errors.New("The server list was not confirmed; nothing was written")
 	}
-	return statePolling, nil
+	return nil
 }
 
-func (opts *setupOptionsType) getTenantToken(
-	client *http.Client, userToken []byte) error {
-	type tenantTokenResponse struct {
-		Token string `json:"tenant_token"`
-	}
+// isPlainHTTP returns true if serverURL uses unencrypted http.
+func isPlainHTTP(serverURL string) bool {
+	return strings.HasPrefix(strings.ToLower(serverURL), "http://")
+}
 
-	tokReq, err := http.NewRequest(
-		"GET",
-		hostedMenderURL+
-			"/api/management/v1/tenantadm/user/tenant",
-		nil)
-	if err != nil {
-		return errors.Wrap(err,
-			"Error creating tenant token request")
+// isValidServerURL returns true if serverURL is an absolute http or https
+// URL with a host, and optionally a port and a path.
+func isValidServerURL(serverURL string) bool {
+	if strings.IndexFunc(serverURL, unicode.IsSpace) >= 0 {
+		return false
 	}
-	tokReq.Header = map[string][]string{
-		"Authorization": {"Bearer " + string(userToken)},
+	u, err := url.Parse(serverURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Hostname() == "" {
+		return false
 	}
-	rsp, err := client.Do(tokReq)
-	if rsp != nil {
-		defer rsp.Body.Close()
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return false
+		}
 	}
-	if err != nil {
-		return errors.Wrap(err,
-			"Tenant token request FAILED.")
+	return true
+}
+
+// isHTTPS returns true if serverURL uses https.
+func isHTTPS(serverURL string) bool {
+	return strings.HasPrefix(strings.ToLower(serverURL), "https://")
+}
+
+// checkInsecureHTTP warns about (or, under --strict, rejects) a plain http
+// server URL, unless --allow-insecure-http is given. With the flag, demo
+// server side effects are not applied, since such a server is typically a
+// locally running mock server.
+func (opts *setupOptionsType) checkInsecureHTTP() error {
+	if !isPlainHTTP(opts.serverURL) {
+		return nil
 	}
-	data, err := ioutil.ReadAll(rsp.Body)
-	if err != nil {
+	if opts.allowInsecureHTTP {
+		if opts.demoServer {
+			log.Infof("Not installing the demo certificate nor modifying "+
+				"%s for the plain http server %s",
+				opts.hostsFilePath(), opts.serverURL)
+			opts.demoServer = false
+		}
+		return nil
+	}
+	msg := fmt.Sprintf("The server URL %s uses plain http, so the "+
+		"connection will not be encrypted; use --allow-insecure-http "+
+		"if this is intended", opts.serverURL)
+	if opts.strict {
+		return errors.New(msg)
+	}
+	opts.warnings.warn(msg)
+	return nil
+}
+
+// checkPollRateLimits estimates the requests per day made by each device
+// with the chosen poll intervals, and warns when they are short enough to
+// typically be rate limited. This is only an estimate computed locally.
+func (opts *setupOptionsType) checkPollRateLimits() {
+	if !opts.checkRateLimits || opts.demoIntervals {
+		return
+	}
+	const secondsPerDay = 24 * 60 * 60
+	polls := []struct {
+		name     string
+		interval int
+	}{
+		{"update", opts.updatePollInterval},
+		{"inventory", opts.invPollInterval},
+	}
+	requests := 0
+	var short []string
+	for _, poll := range polls {
+		if poll.interval <= 0 {
+			continue
+		}
+		requests += secondsPerDay / poll.interval
+		if poll.interval < rateLimitedPollInterval {
+			short = append(short, fmt.Sprintf("%s poll %ds",
+				poll.name, poll.interval))
+		}
+	}
+	if len(short) == 0 {
+		return
+	}
+	opts.warnings.warnf("The %s is shorter than %ds: each device will make "+
+		"about %d requests per day, which may exceed the rate limits of the "+
+		"server across a large fleet", strings.Join(short, " and "),
+		rateLimitedPollInterval, requests)
+}
+
+// checkDemoServerCert checks that the demo certificate given with
+// --demo-cert exists, and warns about (or, under --strict, rejects) a server
+// certificate given together with the demo server, which uses the demo
+// certificate instead, unless --server-cert-overrides-demo is given.
+func (opts *setupOptionsType) checkDemoServerCert() error {
+	if opts.demoCert != "" && opts.usesDemoCert() {
+		if _, err := os.Stat(opts.demoCert); err != nil {
+			return errors.Wrapf(err, "Cannot use the demo certificate "+
+				"given with --demo-cert")
+		}
+	}
+	if !opts.demoServer || opts.hostedMender || opts.serverCert == "" ||
+		opts.certOverridesDemo {
+		return nil
+	}
+	msg := fmt.Sprintf("The server certificate %s is ignored, since the "+
+		"demo server uses the demo certificate; use "+
+		"--server-cert-overrides-demo to use it instead", opts.serverCert)
+	if opts.strict {
+		return errors.New(msg)
+	}
+	opts.warnings.warn(msg)
+	return nil
+}
+
+// checkLoopbackServer warns about (or, under --strict, rejects) a production
+// server whose host is the demo server host or resolves to a loopback
+// address, which is typically left over from a demo setup, since the demo
+// server host only resolves through /etc/hosts. A plain http server allowed
+// with --allow-insecure-http is not checked, as it is typically a locally
+// running mock server.
+func (opts *setupOptionsType) checkLoopbackServer() error {
+	if opts.demoServer || opts.hostedMender || opts.serverURL == "" ||
+		(opts.allowInsecureHTTP && isPlainHTTP(opts.serverURL)) {
+		return nil
+	}
+	u, err := url.Parse(opts.serverURL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	host := u.Hostname()
+	var msg string
+	if demoHost, err := url.Parse(defaultServerURL); err == nil &&
+		strings.EqualFold(host, demoHost.Hostname()) {
+		msg = fmt.Sprintf("The server URL %s uses the demo server host, "+
+			"which only resolves through the /etc/hosts entry of a demo "+
+			"setup; use the host of your production server", opts.serverURL)
+	} else if ip := opts.loopbackAddress(host); ip != "" {
+		msg = fmt.Sprintf("The server URL %s resolves to the loopback "+
+			"address %s, so the device would connect to itself; use the "+
+			"host of your production server", opts.serverURL, ip)
+	} else {
+		return nil
+	}
+	if opts.strict {
+		return errors.New(msg)
+	}
+	opts.warnings.warn(msg)
+	return nil
+}
+
+// privateHostSuffixes are the domains of hosts which are only reachable on
+// a private network, and therefore rarely have a publicly trusted
+// certificate.
+var privateHostSuffixes = []string{
+	".local", ".lan", ".internal", ".localdomain", ".home.arpa", ".test",
+}
+
+// checkServerCertScheme warns when the server certificate does not fit the
+// scheme of the server URLs: a certificate given for a plain http server is
+// not used, and an https server with a private looking host, such as an IP
+// address or a .local name, usually has a self-signed certificate, which
+// the device will not trust without one (or SkipVerify).
+func (opts *setupOptionsType) checkServerCertScheme(
+	config *conf.MenderConfigFromFile) {
+	if opts.demoServer || opts.hostedMender {
+		return
+	}
+	for _, serverURL := range append([]string{opts.serverURL},
+		opts.fallbackServers...) {
+		u, err := url.Parse(serverURL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		switch {
+		case u.Scheme == "http" && opts.serverCert != "":
+			opts.warnings.warnf("The server certificate %s is not used "+
+				"for the plain http server %s", opts.serverCert, serverURL)
+		case u.Scheme == "https" && opts.serverCert == "" &&
+			!config.SkipVerify && isPrivateLookingHost(u.Hostname()):
+			opts.warnings.warnf("The server %s looks like a private host, "+
+				"which usually has a self-signed certificate; the device "+
+				"will only trust it if its CA is in the system certificate "+
+				"store, otherwise give it with --server-cert", serverURL)
+		}
+	}
+}
+
+// isPrivateLookingHost tells whether host is an IP address, a name without
+// a domain or a name in one of privateHostSuffixes. Loopback hosts are left
+// to checkLoopbackServer.
+func isPrivateLookingHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return !ip.IsLoopback()
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" {
+		return false
+	}
+	if !strings.Contains(host, ".") {
+		return true
+	}
+	for _, suffix := range privateHostSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopbackAddress returns the first loopback address host resolves to, or
+// "" if none or if it cannot be resolved.
+func (opts *setupOptionsType) loopbackAddress(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() {
+			return ip.String()
+		}
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		opts.checkTimeoutDuration())
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		log.Debugf("Cannot resolve %s: %v", host, err)
+		return ""
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLoopback() {
+			return addr.IP.String()
+		}
+	}
+	return ""
+}
+
+var validIPRegex = regexp.MustCompile(validIPRegularExpression)
+
+// parseServerIP splits a server IP such as 10.0.0.2, 10.0.0.2:8443, ::1 or
+// [::1]:8443 into the address and the port, which is empty if not given.
+func parseServerIP(serverIP string) (ip string, port string, ok bool) {
+	if !validIPRegex.MatchString(serverIP) {
+		return "", "", false
+	}
+	ip = serverIP
+	if host, p, err := net.SplitHostPort(serverIP); err == nil {
+		ip, port = host, p
+	} else {
+		ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", "", false
+	}
+	return parsed.String(), port, true
+}
+
+func (opts *setupOptionsType) askServerIP(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	var err error
+	if !ctx.IsSet("server-url") {
+		// Set default server URL
+		// -- can be modified by flag.
+		opts.serverURL = defaultServerURL
+	}
+	if _, _, ok := parseServerIP(opts.serverIP); ok {
+		// IP added by cmdline
+		return statePolling, nil
+	}
+	if err := stdin.requireFlag("server-ip"); err != nil {
+		return stateInvalid, err
+	}
+	opts.serverIP, err = stdin.promptUser(
+		promptServerIP, false)
+	if err != nil {
+		return stateInvalid, err
+	}
+	for {
+		if opts.serverIP == "" {
+			// default
+			opts.serverIP = defaultServerIP
+			break
+		} else if _, _, ok := parseServerIP(opts.serverIP); !ok {
+			opts.serverIP, err = stdin.promptUser(
+				rspInvalidIP, false)
+			if err != nil {
+				return stateInvalid, err
+			}
+		} else {
+			break
+		}
+	}
+	return statePolling, nil
+}
+
+func (opts *setupOptionsType) askServerCert(ctx *cli.Context,
+	stdin *stdinReader) (int, error) {
+	var err error
+	if ctx.IsSet("server-cert") {
+		return statePolling, nil
+	}
+	if err := stdin.requireFlag("server-cert"); err != nil {
+		return stateInvalid, err
+	}
+	opts.serverCert, err = stdin.promptUser(
+		promptServerCert, false)
+	if err != nil {
+		return stateInvalid, err
+	}
+	for {
+		if opts.serverCert == "" {
+			// No certificates is allowed
+			break
+		} else if _, err = os.Stat(opts.serverCert); err != nil {
+			rsp := fmt.Sprintf(rspFileNotExist, opts.serverCert)
+			opts.serverCert, err = stdin.promptUser(
+				rsp, false)
+			if err != nil {
+				return stateInvalid, err
+			}
+		} else {
+			break
+		}
+	}
+	return stateClientCert, nil
+}
+
+// newLoginClient creates the client for the Hosted Mender requests, which
+// gives up after --login-timeout seconds.
+func (opts *setupOptionsType) newLoginClient() (*http.Client, error) {
+	client, err := opts.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = time.Duration(opts.loginTimeoutSeconds()) * time.Second
+	return client, nil
+}
+
+func (opts *setupOptionsType) loginTimeoutSeconds() int {
+	if opts.loginTimeout == 0 {
+		return defaultLoginTimeout
+	}
+	return opts.loginTimeout
+}
+
+// loginRequestError returns the error for a failed Hosted Mender request,
+// telling a timeout apart from other connection errors.
+func (opts *setupOptionsType) loginRequestError(err error, msg string) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errors.Errorf(errMsgLoginTimeoutF, msg,
+			opts.loginTimeoutSeconds())
+	}
+	return errors.Wrap(err, msg)
+}
+
+func (opts *setupOptionsType) getTenantToken(
+	client *http.Client, userToken []byte) error {
+	defer opts.timings.start(phaseTokenFetch)()
+
+	type tenantTokenResponse struct {
+		Token string `json:"tenant_token"`
+	}
+
+	tokReq, err := http.NewRequest(
+		"GET",
+		HostedMenderAPIURL+
+			"/api/management/v1/tenantadm/user/tenant",
+		nil)
+	if err != nil {
+		return errors.Wrap(err,
+			"Error creating tenant token request")
+	}
+	tokReq.Header = map[string][]string{
+		"Authorization": {"Bearer " + string(userToken)},
+	}
+	rsp, err := client.Do(tokReq)
+	if rsp != nil {
+		defer rsp.Body.Close()
+	}
+	if err != nil {
+		return opts.loginRequestError(err, "Tenant token request FAILED")
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return errors.Errorf(
+			"Tenant token request FAILED: unexpected statuscode %d",
+			rsp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
 		return errors.Wrap(err,
 			"Reading tenant token FAILED.")
 	}
@@ -624,11 +1585,15 @@ func (opts *setupOptionsType) tryLoginhostedMender(
 	var client *http.Client
 	var authReq *http.Request
 	var response *http.Response
+	stopTiming := opts.timings.start(phaseLogin)
+	client, err = opts.newLoginClient()
+	if err != nil {
+		return err
+	}
 	for {
-		client = &http.Client{}
 		authReq, err = http.NewRequest(
 			"POST",
-			hostedMenderURL+
+			HostedMenderAPIURL+
 				"/api/management/v1/useradm/auth/login",
 			nil)
 		if err != nil {
@@ -654,7 +1619,7 @@ func (opts *setupOptionsType) tryLoginhostedMender(
 				}
 				continue
 			}
-			return err
+			return opts.loginRequestError(err, "Login request FAILED")
 		} else if response.StatusCode == 401 {
 			fmt.Println(rspHMLogin)
 			err = opts.askCredentials(stdin, validEmailRegex)
@@ -672,14 +1637,38 @@ func (opts *setupOptionsType) tryLoginhostedMender(
 
 	// Get tenant token
 	userToken, err := ioutil.ReadAll(response.Body)
+	stopTiming()
 	if err != nil {
 		return errors.Wrap(err,
 			"Error reading authorization token")
 	}
+	opts.hostedUserToken = userToken
 
 	return opts.getTenantToken(client, userToken)
 }
 
+// credentialsFromEnv takes the Hosted Mender credentials which were not
+// given with --username and --password from the MENDER_SETUP_USERNAME and
+// MENDER_SETUP_PASSWORD environment variables, keeping the password out of
+// the command line. The precedence is: flag, environment variable, prompt.
+func (opts *setupOptionsType) credentialsFromEnv(ctx *cli.Context) {
+	for _, credential := range []struct {
+		flag, env string
+		value     *string
+	}{
+		{"username", envUsername, &opts.username},
+		{"password", envPassword, &opts.password},
+	} {
+		if ctx.IsSet(credential.flag) {
+			continue
+		}
+		if value := os.Getenv(credential.env); value != "" {
+			*credential.value = value
+			_ = ctx.Set(credential.flag, value)
+		}
+	}
+}
+
 func (opts *setupOptionsType) askHostedMenderCredentials(ctx *cli.Context,
 	stdin *stdinReader) (int, error) {
 	validEmailRegex, err := regexp.Compile(validEmailRegularExpression)
@@ -688,9 +1677,36 @@ func (opts *setupOptionsType) askHostedMenderCredentials(ctx *cli.Context,
 	}
 
 	if ctx.IsSet("tenant-token") {
+		if opts.useServerPolls {
+			opts.warnings.warn("Not using the recommended poll intervals: " +
+				"they need a login, which --tenant-token skips")
+		}
+		return statePolling, nil
+	}
+	opts.credentialsFromEnv(ctx)
+	if opts.accessToken != "" {
+		// A Personal Access Token authorizes the tenant token request
+		// directly, without logging in.
+		client, err := opts.newLoginClient()
+		if err != nil {
+			return stateInvalid, err
+		}
+		if err = opts.getTenantToken(client, []byte(opts.accessToken)); err != nil {
+			return stateInvalid, err
+		}
+		if opts.useServerPolls {
+			opts.applyRecommendedPolls(ctx, []byte(opts.accessToken))
+		}
 		return statePolling, nil
 	}
 	if !(ctx.IsSet("username") && ctx.IsSet("password")) {
+		for _, flag := range []string{"username", "password"} {
+			if !ctx.IsSet(flag) {
+				if err := stdin.requireFlag(flag); err != nil {
+					return stateInvalid, err
+				}
+			}
+		}
 		fmt.Println(promptCredentials)
 		if err := opts.askCredentials(stdin, validEmailRegex); err != nil {
 			return stateInvalid, err
@@ -706,6 +1722,9 @@ func (opts *setupOptionsType) askHostedMenderCredentials(ctx *cli.Context,
 	if err != nil {
 		return stateInvalid, err
 	}
+	if opts.useServerPolls {
+		opts.applyRecommendedPolls(ctx, opts.hostedUserToken)
+	}
 
 	return statePolling, nil
 }
@@ -714,6 +1733,9 @@ func (opts *setupOptionsType) askUpdatePoll(ctx *cli.Context,
 	stdin *stdinReader) error {
 	if !ctx.IsSet("update-poll") ||
 		opts.updatePollInterval < minimumPollInterval {
+		if err := stdin.requireFlag("update-poll"); err != nil {
+			return err
+		}
 		rsp, err := stdin.promptUser(
 			promptUpdatePoll, false)
 		if err != nil {
@@ -745,6 +1767,9 @@ func (opts *setupOptionsType) askInventoryPoll(ctx *cli.Context,
 	stdin *stdinReader) error {
 	if !ctx.IsSet("inventory-poll") ||
 		opts.invPollInterval < minimumPollInterval {
+		if err := stdin.requireFlag("inventory-poll"); err != nil {
+			return err
+		}
 		rsp, err := stdin.promptUser(
 			promptInventoryPoll, false)
 		if err != nil {
@@ -754,7 +1779,7 @@ func (opts *setupOptionsType) askInventoryPoll(ctx *cli.Context,
 			if rsp == "" {
 				opts.invPollInterval = defaultInventoryPoll
 				break
-			} else if opts.invPollInterval, err = strconv.Atoi(
+			} else if opts.invPollInterval, err = parseInventoryPoll(
 				rsp); err != nil {
 				rsp, err = stdin.promptUser(
 					rspNotSeconds, false)
@@ -776,6 +1801,9 @@ func (opts *setupOptionsType) askRetryPoll(ctx *cli.Context,
 	stdin *stdinReader) error {
 	if !ctx.IsSet("retry-poll") ||
 		opts.retryPollInterval < minimumPollInterval {
+		if err := stdin.requireFlag("retry-poll"); err != nil {
+			return err
+		}
 		rsp, err := stdin.promptUser(
 			promptRetryPoll, false)
 		if err != nil {
@@ -803,9 +1831,39 @@ func (opts *setupOptionsType) askRetryPoll(ctx *cli.Context,
 	return nil
 }
 
+// askRetryPollCount asks for the RetryPollCount.
+func (opts *setupOptionsType) askRetryPollCount(stdin *stdinReader) error {
+	rsp, err := stdin.promptUser(promptRetryPollCount, false)
+	if err != nil {
+		return err
+	}
+	for {
+		if rsp == "" {
+			opts.retryPollCount = 0
+			break
+		} else if opts.retryPollCount, err = strconv.Atoi(
+			rsp); err != nil || opts.retryPollCount < 0 {
+			rsp, err = stdin.promptUser(rspInvalidCount, false)
+			if err != nil {
+				return err
+			}
+		} else {
+			break
+		}
+	}
+	opts.retryPollCountSet = true
+	return nil
+}
+
 func (opts *setupOptionsType) askPollingIntervals(ctx *cli.Context,
 	stdin *stdinReader) (int, error) {
+	if ctx.IsSet("retry-poll-count") {
+		opts.retryPollCountSet = true
+	}
 	if !ctx.IsSet("demo-polling") {
+		if err := stdin.requireFlag("demo-polling"); err != nil {
+			return stateInvalid, err
+		}
 		demoIntervals, err := stdin.promptYN(promptDemoIntervals, true)
 		if err != nil {
 			return stateInvalid, err
@@ -814,9 +1872,28 @@ func (opts *setupOptionsType) askPollingIntervals(ctx *cli.Context,
 	}
 
 	if opts.demoIntervals {
-		opts.updatePollInterval = demoUpdatePoll
-		opts.invPollInterval = demoInventoryPoll
-		opts.retryPollInterval = demoRetryPoll
+		// With --no-demo-intervals-clamp, explicitly given intervals are
+		// kept as they are.
+		keep := func(flag string) bool {
+			return opts.noDemoClamp && ctx.IsSet(flag)
+		}
+		if !keep("update-poll") {
+			opts.updatePollInterval = demoUpdatePoll
+		}
+		if !keep("inventory-poll") {
+			opts.invPollInterval = demoInventoryPoll
+		}
+		switch {
+		case keep("retry-poll"):
+		case ctx.IsSet("retry-poll"):
+			// A given retry interval is used with demo polling too,
+			// as long as it is at least minimumPollInterval.
+			if err := opts.askRetryPoll(ctx, stdin); err != nil {
+				return stateInvalid, err
+			}
+		default:
+			opts.retryPollInterval = demoRetryPoll
+		}
 	} else {
 		if err := opts.askUpdatePoll(ctx, stdin); err != nil {
 			return stateInvalid, err
@@ -824,64 +1901,350 @@ func (opts *setupOptionsType) askPollingIntervals(ctx *cli.Context,
 		if err := opts.askInventoryPoll(ctx, stdin); err != nil {
 			return stateInvalid, err
 		}
+		promptsBefore := stdin.prompts
 		if err := opts.askRetryPoll(ctx, stdin); err != nil {
 			return stateInvalid, err
 		}
+		// Only asked together with the retry poll interval, to keep
+		// runs with all the intervals given free of prompts.
+		if stdin.prompts > promptsBefore && !ctx.IsSet("retry-poll-count") {
+			if err := opts.askRetryPollCount(stdin); err != nil {
+				return stateInvalid, err
+			}
+		}
 	}
 
-	return stateDone, nil
+	return opts.nextFeatureState(statePolling), nil
 }
 
 func doSetup(ctx *cli.Context, config *conf.MenderConfigFromFile,
 	opts *setupOptionsType) error {
 	var err error
 	state := stateDeviceType
+	if opts.selectFeatures {
+		state = stateFeatures
+	}
+	var input io.Reader = os.Stdin
+	if opts.promptInput != nil {
+		input = opts.promptInput
+	}
 	stdin := &stdinReader{
-		reader: bufio.NewReader(os.Stdin),
+		reader:         bufio.NewReader(input),
+		nonInteractive: opts.nonInteractive,
 	}
 
 	// Prompt 'wizard' message
-	if !ctx.Bool("quiet") {
+	if !ctx.Bool("quiet") && !opts.toStdout {
 		fmt.Println(promptWizard)
 	}
 
-	// Prompt the user for config options if not specified by flags
-	for state != stateDone {
-		switch state {
-		case stateDeviceType:
-			state, err = opts.askDeviceType(ctx, stdin)
+	opts.keepExistingSettings(ctx, config)
 
-		case stateHostedMender:
-			state, err = opts.askHostedMender(ctx, stdin)
+	// Prompt the user for config options if not specified by flags.
+	stopTiming := opts.timings.start(phasePrompts)
+	next := map[int]int{}
+	history, err := opts.askStates(ctx, stdin, state, nil, next)
+	if err != nil {
+		return err
+	}
+	if err := opts.reviewSettings(ctx, stdin, history, next); err != nil {
+		return err
+	}
+	stopTiming()
 
-		case stateDemoServer:
-			state, err = opts.askDemoServer(ctx, stdin)
+	if err := opts.checkProductionDevice(ctx); err != nil {
+		return err
+	}
+	if err := opts.checkInsecureHTTP(); err != nil {
+		return err
+	}
+	if err := opts.checkDemoServerCert(); err != nil {
+		return err
+	}
+	if err := opts.checkLoopbackServer(); err != nil {
+		return err
+	}
+	opts.checkServerCertScheme(config)
+	opts.checkServerCertExpiry()
+	opts.checkTenantTokenRegion()
+	opts.checkPollRateLimits()
+	if err := opts.policy.checkOptions(opts); err != nil {
+		return err
+	}
+	if err := opts.confirmServers(ctx, stdin); err != nil {
+		return err
+	}
+	if opts.usesDemoCert() {
+		if err := opts.confirmDemoCert(ctx, stdin); err != nil {
+			return err
+		}
+	}
+	if err := opts.confirmHostLookup(ctx, stdin); err != nil {
+		return err
+	}
+	if opts.checkReachability && opts.demoServer && !opts.hostedMender {
+		if err := opts.checkDemoServerTLS(); err != nil {
+			return err
+		}
+	}
+	return opts.saveConfigOptionsRetrying(ctx, stdin, config)
+}
 
-		case stateServerURL:
-			state, err = opts.askServerURL(ctx, stdin)
+// askState asks the questions of state, if not answered with flags,
+// returning the next state.
+func (opts *setupOptionsType) askState(ctx *cli.Context, stdin *stdinReader,
+	state int) (int, error) {
+	switch state {
+	case stateDeviceType:
+		return opts.askDeviceType(ctx, stdin)
 
-		case stateServerIP:
-			state, err = opts.askServerIP(ctx, stdin)
+	case stateHostedMender:
+		return opts.askHostedMender(ctx, stdin)
 
-		case stateServerCert:
-			state, err = opts.askServerCert(ctx, stdin)
+	case stateDemoServer:
+		return opts.askDemoServer(ctx, stdin)
 
-		case stateCredentials:
-			state, err = opts.askHostedMenderCredentials(ctx, stdin)
+	case stateServerURL:
+		return opts.askServerURL(ctx, stdin)
 
-		case statePolling:
-			state, err = opts.askPollingIntervals(ctx, stdin)
-		}
-		if err != nil {
-			return err
-		}
-	} // END for {state}
-	return opts.saveConfigOptions(config)
-}
+	case stateServerIP:
+		return opts.askServerIP(ctx, stdin)
 
-func (opts *setupOptionsType) saveConfigOptions(
+	case stateServerCert:
+		return opts.askServerCert(ctx, stdin)
+
+	case stateClientCert:
+		return opts.askClientCert(ctx, stdin)
+
+	case stateCredentials:
+		return opts.askHostedMenderCredentials(ctx, stdin)
+
+	case statePolling:
+		return opts.askPollingIntervals(ctx, stdin)
+
+	case stateFeatures:
+		return opts.askFeatures(ctx, stdin)
+
+	case stateMTLS:
+		return opts.askMTLS(ctx, stdin)
+
+	case stateConnectivity:
+		return opts.askConnectivity(ctx, stdin)
+
+	case stateStateScripts:
+		return opts.askStateScripts(ctx, stdin)
+
+	case stateArtifactKeys:
+		return opts.askArtifactKeys(ctx, stdin)
+	}
+	return stateInvalid, errors.Errorf("Unknown setup state %d", state)
+}
+
+// askStates asks the questions from state on until stateDone. The states
+// which prompted the user are appended to history, so that entering
+// backToken can return to the previous one, and the state each one led to
+// is kept in next.
+func (opts *setupOptionsType) askStates(ctx *cli.Context, stdin *stdinReader,
+	state int, history []int, next map[int]int) ([]int, error) {
+	for state != stateDone {
+		current := state
+		promptsBefore := stdin.prompts
+		var err error
+		state, err = opts.askState(ctx, stdin, current)
+		if err == errGoBack {
+			if len(history) == 0 {
+				fmt.Println(rspNoPreviousQuestion)
+				state = current
+			} else {
+				state = history[len(history)-1]
+				history = history[:len(history)-1]
+			}
+			continue
+		} else if err != nil {
+			return history, err
+		}
+		next[current] = state
+		if stdin.prompts > promptsBefore {
+			history = append(history, current)
+			if opts.explain && !ctx.Bool("quiet") {
+				fmt.Println(opts.explanation(current))
+			}
+		}
+	}
+	return history, nil
+}
+
+// configWriteError is a failure to write the configuration file, such as a
+// full disk, which the operator may be able to fix before retrying.
+type configWriteError struct {
+	error
+}
+
+// saveConfigOptionsRetrying saves the configuration, and asks whether to
+// retry when writing the configuration file fails, so that the operator can
+// fix the condition without answering all the questions again, unless
+// confirmations are skipped.
+func (opts *setupOptionsType) saveConfigOptionsRetrying(ctx *cli.Context,
+	stdin *stdinReader, config *conf.MenderConfigFromFile) error {
+	for {
+		err := opts.saveConfigOptions(config)
+		var writeErr *configWriteError
+		if !errors.As(err, &writeErr) || opts.skipConfirmations(ctx) {
+			return err
+		}
+		fmt.Printf(promptRetryConfigWrite, writeErr)
+		retry, err := stdin.promptRetryAbort()
+		if err != nil {
+			return err
+		}
+		if !retry {
+			return writeErr.error
+		}
+	}
+}
+
+// promptRetryAbort returns true if the user chose to retry, which is the
+// default.
+func (stdin *stdinReader) promptRetryAbort() (bool, error) {
+	rsp, err := stdin.promptUser(promptRetryAbort, false)
+	for err == nil {
+		switch strings.ToLower(strings.TrimSpace(rsp)) {
+		case "", "r", "retry":
+			return true, nil
+		case "a", "abort":
+			return false, nil
+		}
+		rsp, err = stdin.promptUser(rspSelectRetryAbort, false)
+	}
+	return false, err
+}
+
+func (opts *setupOptionsType) saveConfigOptions(
 	config *conf.MenderConfigFromFile) error {
-	if opts.demoIntervals {
+	if err := opts.applyConfigOptions(config); err != nil {
+		return err
+	}
+
+	if opts.toStdout {
+		// The configuration is for another device, so nothing on this
+		// one is modified.
+		if opts.discoveredCert != nil {
+			opts.warnings.warnf("Not writing the discovered server "+
+				"certificate; install it as %s on the device:\n%s",
+				opts.serverCert, opts.discoveredCert)
+		}
+		return opts.printConfig(config)
+	}
+
+	var err error
+	if opts.dryRun {
+		err = opts.reportConfigFiles(config)
+	} else {
+		err = opts.writeConfigFiles(config)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.demoServer && !opts.hostedMender {
+		stopTiming := opts.timings.start(phaseHostsUpdate)
+		opts.maybeAddHostLookup()
+		stopTiming()
+	}
+
+	if opts.skipDemoTrust {
+		log.Info("Not installing the Mender demo cert in local trust")
+	} else if opts.usesDemoCert() {
+		stopTiming := opts.timings.start(phaseCertInstall)
+		err = opts.installDemoCertificateLocalTrust()
+		stopTiming()
+		if err != nil {
+			// Some systems have a read-only or immutable trust store,
+			// where the device can still be set up with the
+			// ServerCertificate alone, so this is fatal only under
+			// --strict.
+			if opts.strict {
+				return errors.Wrap(err,
+					"Unable to install Mender demo cert in local trust")
+			}
+			opts.warnings.warnf("Unable to install Mender demo cert in local trust: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// writeConfigFiles writes the configuration, together with the files which
+// accompany it.
+func (opts *setupOptionsType) writeConfigFiles(
+	config *conf.MenderConfigFromFile) error {
+	if err := opts.writeDiscoveredCert(); err != nil {
+		return err
+	}
+	if !opts.noBackup {
+		if err := backupConfigFile(opts.configPath); err != nil {
+			return err
+		}
+	}
+	var configXattrs, deviceTypeXattrs fileXattrs
+	if opts.preserveACLs {
+		var err error
+		if configXattrs, err = readXattrs(opts.configPath); err != nil {
+			return err
+		}
+		if deviceTypeXattrs, err = readXattrs(config.DeviceTypeFile); err != nil {
+			return err
+		}
+	}
+	stopTiming := opts.timings.start(phaseConfigWrite)
+	var err error
+	if opts.sortedKeys {
+		err = conf.SaveConfigFileSortedKeys(
+			config, opts.configPath, opts.configFormat)
+	} else {
+		err = conf.SaveConfigFileFormat(
+			config, opts.configPath, opts.configFormat)
+	}
+	stopTiming()
+	if err != nil {
+		return &configWriteError{err}
+	}
+	if err := configXattrs.restore(opts.configPath); err != nil {
+		return err
+	}
+	if opts.writeChecksum {
+		if err := writeChecksumFile(opts.configPath); err != nil {
+			return err
+		}
+	}
+	stopTiming = opts.timings.start(phaseDeviceTypeWrite)
+	err = conf.WriteFileAtomic(
+		config.DeviceTypeFile, opts.deviceTypeFileData(), 0644)
+	stopTiming()
+	if err != nil {
+		return errors.Wrap(err, "Error writing to devicefile.")
+	}
+	if err := deviceTypeXattrs.restore(config.DeviceTypeFile); err != nil {
+		return err
+	}
+	if opts.envFile != "" {
+		if err := writeEnvFile(opts.envFile, config, opts.deviceType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyConfigOptions sets the chosen options in config, without writing
+// anything.
+func (opts *setupOptionsType) applyConfigOptions(
+	config *conf.MenderConfigFromFile) error {
+	if opts.demoIntervals && opts.noDemoClamp {
+		config.UpdatePollIntervalSeconds = opts.updatePollInterval
+		config.InventoryPollIntervalSeconds = opts.invPollInterval
+		config.RetryPollIntervalSeconds = opts.retryPollInterval
+		config.UpdateControlMapExpirationTimeSeconds = demoControlMapExpiration
+		config.UpdateControlMapBootExpirationTimeSeconds = demoControlMapBootExpiration
+	} else if opts.demoIntervals {
 		if opts.updatePollInterval > minimumPollInterval {
 			config.UpdatePollIntervalSeconds = opts.
 				updatePollInterval
@@ -894,7 +2257,7 @@ func (opts *setupOptionsType) saveConfigOptions(
 		} else {
 			config.InventoryPollIntervalSeconds = demoInventoryPoll
 		}
-		if opts.retryPollInterval > minimumPollInterval {
+		if opts.retryPollInterval >= minimumPollInterval {
 			config.RetryPollIntervalSeconds = opts.
 				retryPollInterval
 		} else {
@@ -908,112 +2271,545 @@ func (opts *setupOptionsType) saveConfigOptions(
 		config.RetryPollIntervalSeconds = opts.retryPollInterval
 	}
 
-	if opts.demoServer && !opts.hostedMender {
-		config.ServerCertificate = getMenderDemoCertPath()
+	if opts.retryPollCountSet {
+		config.RetryPollCount = opts.retryPollCount
+	}
+
+	if opts.usesDemoCert() {
+		config.ServerCertificate = opts.demoCertPath()
 	} else {
 		config.ServerCertificate = opts.serverCert
 	}
 
-	config.TenantToken = opts.tenantToken
+	// Keep a token from an existing configuration unless a new one was
+	// provided or obtained.
+	if opts.tenantToken != "" {
+		config.TenantToken = opts.tenantToken
+	} else if config.TenantToken == "" && len(config.Servers) > 0 {
+		// The existing configuration may be in the per-server style.
+		config.TenantToken = config.Servers[0].TenantToken
+	}
+	if opts.minTLSVersion != "" {
+		config.MinTLSVersion = opts.minTLSVersion
+	}
+	if opts.daemonLogLevel != "" {
+		config.DaemonLogLevel = opts.daemonLogLevel
+	}
+	if opts.updateLogPath != "" {
+		config.UpdateLogPath = opts.updateLogPath
+	}
+	if opts.profile != "" {
+		config.Connectivity = opts.connectivity
+	}
+	if opts.clientCert != "" {
+		config.HttpsClient.Certificate = opts.clientCert
+		config.HttpsClient.Key = opts.clientKey
+		config.HttpsClient.SSLEngine = opts.sslEngine
+	}
+	if opts.artifactVerifyKey != "" {
+		config.ArtifactVerifyKey = opts.artifactVerifyKey
+		config.ArtifactVerifyKeys = nil
+	} else if len(opts.artifactKeys) > 0 {
+		config.ArtifactVerifyKey = ""
+		config.ArtifactVerifyKeys = opts.artifactKeys
+	}
+	opts.saveFeatures(config)
+	if opts.disableKeepAlive {
+		config.Connectivity.DisableKeepAlive = true
+	}
+	if opts.idleConnTimeout > 0 {
+		config.Connectivity.IdleConnTimeoutSeconds = opts.idleConnTimeout
+	}
+	if opts.pollJitter > 0 {
+		config.PollJitterPercent = opts.pollJitter
+	}
 
 	// Make sure devicetypefile and serverURL is set
 	if config.DeviceTypeFile == "" {
 		// Default devicetype file as defined in device.go
 		config.DeviceTypeFile = path.Join(conf.GetStateDirPath(), "device_type")
 	}
-	config.Servers = []conf.MenderServer{
-		{
-			ServerURL: opts.serverURL},
+	// Without a new server URL, keep the servers of the existing
+	// configuration, into which conf.LoadConfig migrated a legacy ServerURL.
+	if (opts.serverURL != "" && !opts.keepServers) || len(config.Servers) == 0 {
+		config.Servers = []conf.MenderServer{
+			{
+				ServerURL: opts.serverURL},
+		}
+		for _, serverURL := range opts.fallbackServers {
+			config.Servers = append(config.Servers,
+				conf.MenderServer{ServerURL: serverURL})
+		}
+	}
+	if opts.serverConfigStyle == serverConfigPerServer {
+		for i := range config.Servers {
+			config.Servers[i].ServerCertificate = config.ServerCertificate
+			config.Servers[i].TenantToken = config.TenantToken
+		}
+		config.ServerCertificate = ""
+		config.TenantToken = ""
 	}
 
-	// Avoid possibility of conflicting ServerURL from an old config
-	config.ServerURL = ""
+	if opts.keepServerURL {
+		// For clients still reading ServerURL as a fallback.
+		config.ServerURL = config.Servers[0].ServerURL
+	} else {
+		// Avoid possibility of conflicting ServerURL from an old config
+		config.ServerURL = ""
+	}
 
-	if err := conf.SaveConfigFile(config, opts.configPath); err != nil {
+	return opts.policy.checkConfig(config)
+}
+
+// marshalConfig encodes config the way it is written, ending with a newline.
+func (opts *setupOptionsType) marshalConfig(
+	config *conf.MenderConfigFromFile) ([]byte, error) {
+	var data []byte
+	var err error
+	if opts.sortedKeys {
+		data, err = conf.MarshalConfigSortedKeys(config, opts.configFormat)
+	} else {
+		data, err = conf.MarshalConfig(config, opts.configFormat)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	return data, nil
+}
+
+// printConfig prints the configuration to stdout instead of writing it.
+func (opts *setupOptionsType) printConfig(
+	config *conf.MenderConfigFromFile) error {
+	data, err := opts.marshalConfig(config)
+	if err != nil {
 		return err
 	}
-	err := ioutil.WriteFile(config.DeviceTypeFile,
-		[]byte("device_type="+opts.deviceType+"\n"), 0644)
+	_, err = os.Stdout.Write(data)
+	return errors.Wrap(err, "Error writing the configuration to stdout")
+}
+
+const promptSummaryF = "Configuration: %s\nServer URL: %s\n" +
+	"Device type: %s\nPoll intervals: %s"
+
+// summary returns the written configuration at a glance: where it is, the
+// primary server, the device type and the poll intervals.
+func (opts *setupOptionsType) summary(config *conf.MenderConfigFromFile) string {
+	configPath, err := filepath.Abs(opts.configPath)
 	if err != nil {
-		return errors.Wrap(err, "Error writing to devicefile.")
+		configPath = opts.configPath
 	}
-	if opts.demoServer && !opts.hostedMender {
-		opts.maybeAddHostLookup()
+	serverURL := config.ServerURL
+	if len(config.Servers) > 0 {
+		serverURL = config.Servers[0].ServerURL
 	}
+	return fmt.Sprintf(promptSummaryF, configPath, serverURL, opts.deviceType,
+		describePollIntervals(config.UpdatePollIntervalSeconds,
+			config.InventoryPollIntervalSeconds,
+			config.RetryPollIntervalSeconds))
+}
 
-	if opts.demoServer && (config.ServerCertificate == getMenderDemoCertPath()) {
-		err = opts.installDemoCertificateLocalTrust()
-		if err != nil {
-			log.Warnf("Unable to install Mender demo cert in local trust: %s", err.Error())
+// equivalentCommand returns a mender-setup invocation which reproduces the
+// current options without prompting. Secrets are replaced by references to
+// environment variables, or to the file they were read from. skipVerify is
+// given with --skip-verify, which is not one of the setup options.
+func (opts *setupOptionsType) equivalentCommand(skipVerify bool) string {
+	args := []string{"mender-setup"}
+	addArg := func(flag string, value ...string) {
+		args = append(args, "--"+flag)
+		for _, v := range value {
+			args = append(args, shellQuote(v))
 		}
 	}
 
-	return nil
+	if opts.prefix != "" {
+		addArg("prefix", opts.prefix)
+	}
+	if opts.configPath != "" && opts.configPath != conf.DefaultConfFile {
+		addArg("config", opts.configPath)
+	}
+	addArg("device-type", opts.deviceType)
+	addTenantToken := func() {
+		if opts.tenantTokenFile != "" {
+			addArg("tenant-token-file", opts.tenantTokenFile)
+		} else {
+			args = append(args, "--tenant-token", `"$MENDER_TENANT_TOKEN"`)
+		}
+	}
+	if opts.hostedMender {
+		addArg("hosted-mender")
+		addTenantToken()
+	} else if opts.demoServer {
+		addArg("demo-server")
+		if opts.serverURL != "" && opts.serverURL != defaultServerURL {
+			addArg("server-url", opts.serverURL)
+		}
+		addArg("server-ip", opts.serverIP)
+		if opts.demoCert != "" {
+			addArg("demo-cert", opts.demoCert)
+		}
+		if opts.skipCARefresh {
+			addArg("skip-ca-refresh")
+		}
+		if opts.trustStyle != "" {
+			addArg("trust-style", opts.trustStyle)
+		}
+		if opts.hostsFilePath() != DefaultHostsFile {
+			addArg("hosts-file", opts.hostsFile)
+		}
+		if opts.noHosts {
+			addArg("no-hosts")
+		}
+		if opts.certOverridesDemo && opts.serverCert != "" {
+			addArg("server-cert", opts.serverCert)
+			addArg("server-cert-overrides-demo")
+		}
+	} else {
+		addArg("server-url", strings.Join(
+			append([]string{opts.serverURL}, opts.fallbackServers...), ","))
+		addArg("server-cert", opts.serverCert)
+		if opts.tenantToken != "" {
+			addTenantToken()
+		}
+	}
+	if opts.serverConfigStyle == serverConfigPerServer {
+		addArg("server-config-style", opts.serverConfigStyle)
+	}
+	if opts.keepServerURL {
+		addArg("keep-server-url")
+	}
+	if opts.deviceTypeLineEnding != "" &&
+		opts.deviceTypeLineEnding != lineEndingLF {
+		addArg("device-type-line-ending", opts.deviceTypeLineEnding)
+	}
+	if opts.pollJitter > 0 {
+		addArg("experimental")
+		addArg("poll-jitter", strconv.Itoa(opts.pollJitter))
+	}
+	if opts.profile != "" {
+		addArg("profile", opts.profile)
+	}
+	if opts.demoIntervals {
+		addArg("demo-polling")
+		if opts.retryPollInterval >= minimumPollInterval &&
+			opts.retryPollInterval != demoRetryPoll {
+			addArg("retry-poll", strconv.Itoa(opts.retryPollInterval))
+		}
+	} else {
+		addArg("update-poll", strconv.Itoa(opts.updatePollInterval))
+		if opts.invPollInterval == inventoryPollDisabled {
+			addArg("inventory-poll", pollDisabledKeyword)
+		} else {
+			addArg("inventory-poll", strconv.Itoa(opts.invPollInterval))
+		}
+		addArg("retry-poll", strconv.Itoa(opts.retryPollInterval))
+	}
+	if opts.forceDemo && (opts.demoServer || opts.demoIntervals) {
+		addArg("force-demo")
+	}
+	if opts.retryPollCountSet {
+		addArg("retry-poll-count", strconv.Itoa(opts.retryPollCount))
+	}
+	if opts.clientCert != "" {
+		addArg("client-cert", opts.clientCert)
+		addArg("client-key", opts.clientKey)
+	}
+	if opts.sslEngine != "" {
+		addArg("ssl-engine", opts.sslEngine)
+	}
+	if opts.artifactVerifyKey != "" {
+		addArg("artifact-verify-key", opts.artifactVerifyKey)
+	}
+	for _, key := range opts.artifactKeys {
+		addArg("artifact-verify-keys", key)
+	}
+	if opts.disableKeepAlive {
+		addArg("disable-keepalive")
+	}
+	if opts.idleConnTimeout > 0 {
+		addArg("idle-conn-timeout", strconv.Itoa(opts.idleConnTimeout))
+	}
+	if skipVerify {
+		addArg("skip-verify")
+	}
+	return strings.Join(args, " ")
 }
 
-func (opts *setupOptionsType) maybeAddHostLookup() {
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+,-]+$`)
+
+// shellQuote quotes s for use as a single POSIX shell word.
+func shellQuote(s string) string {
+	if shellSafeRegex.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// hostLookupRoute returns the demo server host and the hosts file line
+// routing it to the server IP.
+func (opts *setupOptionsType) hostLookupRoute() (string, string, error) {
 	// Regex: $1: schema, $2: URL, $3: path
 	re, err := regexp.Compile(`(https?://)?(.*)(/.*)?`)
 	if err != nil {
-		log.Warn("Unable to compile regular expression for parsing " +
-			"server URL.")
-		return
+		return "", "", errors.New("Unable to compile regular expression " +
+			"for parsing server URL.")
 	}
 	// strip schema and path
 	host := re.ReplaceAllString(opts.serverURL, "$2")
 
+	// /etc/hosts takes the bare address, without brackets nor port.
+	ip, _, ok := parseServerIP(opts.serverIP)
+	if !ok {
+		ip = opts.serverIP
+	}
 	// Add "s3.SERVER_URL" as well. This is only called in demo mode, so it
 	// should be a safe assumption.
-	route := fmt.Sprintf("%-15s %s s3.%s", opts.serverIP, host, host)
+	return host, fmt.Sprintf("%-15s %s s3.%s", ip, host, host), nil
+}
+
+// confirmHostLookup asks before the demo server route is added to the hosts
+// file, which can break name resolution on a shared system. Declining is
+// the same as --no-hosts.
+func (opts *setupOptionsType) confirmHostLookup(ctx *cli.Context,
+	stdin *stdinReader) error {
+	if !opts.demoServer || opts.hostedMender || opts.noHosts ||
+		opts.toStdout || opts.skipConfirmations(ctx) {
+		return nil
+	}
+	host, route, err := opts.hostLookupRoute()
+	if err != nil {
+		return nil
+	}
+	hostsFile := opts.hostsFilePath()
+	content, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		// Adding it fails too, and is reported then.
+		return nil
+	}
+	if _, changed := updateHostsContent(
+		string(content), host, route, opts.hostsUpdateMode); !changed {
+		return nil
+	}
+	add, err := stdin.promptYN(
+		fmt.Sprintf(promptAddHostsRouteF, route, hostsFile), true)
+	if err != nil {
+		return err
+	}
+	opts.noHosts = !add
+	return nil
+}
 
-	f, err := os.OpenFile("/etc/hosts", os.O_RDWR, 0644)
+func (opts *setupOptionsType) maybeAddHostLookup() {
+	host, route, err := opts.hostLookupRoute()
 	if err != nil {
-		log.Warnf("Unable to open \"/etc/hosts\" for appending "+
-			"local route \"%s\": %s", route, err.Error())
+		opts.warnings.warn(err.Error())
 		return
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
 
-	// Check if route already exists
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), host) {
-			return
+	hostsFile := opts.hostsFilePath()
+	if opts.noHosts {
+		// A warning, so that it is shown at the default log level.
+		opts.warnings.warnf("Not modifying %q; add this line to it for "+
+			"the demo server: %s", hostsFile, route)
+		return
+	}
+	content, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		opts.warnings.warnf("Unable to open \"%s\" for appending "+
+			"local route \"%s\": %s", hostsFile, route, err.Error())
+		return
+	}
+
+	newContent, changed := updateHostsContent(
+		string(content), host, route, opts.hostsUpdateMode)
+	if !changed {
+		return
+	}
+
+	if opts.dryRun {
+		logDryRunWrite(hostsFile, []byte(newContent))
+		return
+	}
+	err = writeFileInPlace(hostsFile, []byte(newContent))
+	if err != nil {
+		opts.warnings.warnf("Unable to add route \"%s\" to \"%s\": %s",
+			route, hostsFile, err.Error())
+	}
+}
+
+// hostsFilePath returns the hosts file to reconcile the demo server route
+// in, which is given with --hosts-file when provisioning a staging rootfs.
+func (opts *setupOptionsType) hostsFilePath() string {
+	if opts.hostsFile != "" {
+		return opts.hostsFile
+	}
+	return DefaultHostsFile
+}
+
+// updateHostsContent returns the hosts file content with route added for
+// host according to mode, and whether anything changed:
+//
+//	skip:    add route only if no line mentions host (the default)
+//	replace: replace the first line mapping host with route, or add it
+//	append:  always add route, even if host is already mapped
+func updateHostsContent(content, host, route, mode string) (string, bool) {
+	lines := strings.SplitAfter(content, "\n")
+	switch mode {
+	case hostsUpdateAppend:
+		// Fall through to appending below.
+
+	case hostsUpdateReplace:
+		for i, line := range lines {
+			if !hostsLineMapsHost(line, host) {
+				continue
+			}
+			newLine := route
+			if strings.HasSuffix(line, "\n") {
+				newLine += "\n"
+			}
+			if line == newLine {
+				return content, false
+			}
+			lines[i] = newLine
+			return strings.Join(lines, ""), true
+		}
+
+	default: // hostsUpdateSkip
+		for _, line := range lines {
+			if strings.Contains(line, host) {
+				return content, false
+			}
+		}
+	}
+
+	// Make sure the route ends up on a line of its own
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + route + "\n", true
+}
+
+// hostsLineMapsHost returns true if the hosts file line lists host as one
+// of its names, ignoring comments.
+func hostsLineMapsHost(line, host string) bool {
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	for _, name := range fields[1:] {
+		if name == host {
+			return true
 		}
 	}
+	return false
+}
 
-	// Seek to last character
-	_, err = f.Seek(-1, io.SeekEnd)
+// writeFileInPlace overwrites the content of an existing file, keeping its
+// inode and permissions (/etc/hosts is commonly bind mounted).
+func writeFileInPlace(filename string, content []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		log.Warnf("Unable to add route \"%s\" to \"/etc/hosts\": %s",
-			route, err.Error())
+		return err
 	}
-	routeLine := "\n" + route + "\n"
-	// Remove newline from routeLine string if there already is one
-	lastChar := make([]byte, 1)
-	if _, err := f.Read(lastChar); err == nil &&
-		lastChar[0] == byte('\n') {
-		routeLine = routeLine[1:]
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
+}
+
+// usesDemoCert returns true if the demo certificate is written as the
+// ServerCertificate, and so installed in the local trust.
+func (opts *setupOptionsType) usesDemoCert() bool {
+	return opts.demoServer && !opts.hostedMender &&
+		!(opts.certOverridesDemo && opts.serverCert != "")
+}
 
-	_, err = f.WriteString(routeLine)
+// confirmDemoCert shows the SHA-256 fingerprints of the demo certificate
+// and asks whether to install it in the local trust, unless confirmations
+// are skipped.
+func (opts *setupOptionsType) confirmDemoCert(ctx *cli.Context,
+	stdin *stdinReader) error {
+	if opts.skipConfirmations(ctx) {
+		return nil
+	}
+	certPath := opts.demoCertPath()
+	fingerprints, err := certFingerprints(certPath)
 	if err != nil {
-		log.Warnf("Unable to add route \"%s\" to \"/etc/hosts\": %s",
-			route, err.Error())
+		// Installing it fails too, and is reported then.
+		log.Debugf("Cannot read the demo certificate: %s", err.Error())
+		return nil
 	}
+	fmt.Printf(promptDemoCertFingerprints, certPath)
+	for _, fingerprint := range fingerprints {
+		fmt.Println("  " + fingerprint)
+	}
+	trust, err := stdin.promptYN(promptTrustDemoCert, true)
+	if err != nil {
+		return err
+	}
+	opts.skipDemoTrust = !trust
+	return nil
+}
+
+// certFingerprints returns the SHA-256 fingerprints of the PEM certificates
+// in certPath, formatted as colon separated hex like openssl does.
+func certFingerprints(certPath string) ([]string, error) {
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	var fingerprints []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		sum := sha256.Sum256(block.Bytes)
+		hex := make([]string, len(sum))
+		for i, b := range sum {
+			hex[i] = fmt.Sprintf("%02X", b)
+		}
+		fingerprints = append(fingerprints, strings.Join(hex, ":"))
+	}
+	if len(fingerprints) == 0 {
+		return nil, errors.Errorf("No certificates found in %q", certPath)
+	}
+	return fingerprints, nil
 }
 
 func (opts *setupOptionsType) installDemoCertificateLocalTrust() error {
-	menderDemoCertPath := getMenderDemoCertPath()
+	menderDemoCertPath := opts.demoCertPath()
 
-	s, err := os.Open(menderDemoCertPath)
+	data, err := ioutil.ReadFile(menderDemoCertPath)
 	if err != nil {
 		return errors.Wrapf(err,
 			"Cannot open file %q", menderDemoCertPath)
 	}
-	defer s.Close()
+	certs, err := splitCertificateChain(data)
+	if err != nil {
+		return errors.Wrapf(err,
+			"Invalid certificate file %q", menderDemoCertPath)
+	}
+
+	dir, refreshCommand := opts.localTrust()
+	if opts.dryRun {
+		for i, cert := range certs {
+			logDryRunWrite(path.Join(dir,
+				fmt.Sprintf(DefaultLocalTrustMenderFormat, i+1)), cert)
+		}
+		return nil
+	}
 
-	dir := DefaultLocalTrustMenderDir
 	_, err = os.Stat(dir)
 	if os.IsNotExist(err) {
 		err := os.MkdirAll(dir, 0755)
@@ -1023,53 +2819,155 @@ func (opts *setupOptionsType) installDemoCertificateLocalTrust() error {
 		}
 	}
 
-	reader := bufio.NewReader(s)
-	certNum := 1
-	var d *os.File
-
-	for {
-		line, err := reader.ReadBytes(byte('\n'))
-		if errors.Cause(err) == io.EOF {
-			if len(line) == 0 {
-				_ = d.Sync()
-				d.Close()
-				break
-			}
-		} else if err != nil {
-			return errors.Wrap(err, "Cannot read certificate")
+	for i, cert := range certs {
+		fileNameFormat := path.Join(dir, DefaultLocalTrustMenderFormat)
+		fileName := fmt.Sprintf(fileNameFormat, i+1)
+		d, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+		if err != nil {
+			return errors.Wrapf(err,
+				"Cannot create file: %s", fileName)
 		}
-
-		if d == nil {
-			fileNameFormat := path.Join(DefaultLocalTrustMenderDir, DefaultLocalTrustMenderFormat)
-			fileName := fmt.Sprintf(fileNameFormat, certNum)
-			d, err = os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
-			if err != nil {
-				return errors.Wrapf(err,
-					"Cannot create file: %s", fileName)
-			}
+		_, err = d.Write(cert)
+		if err == nil {
+			err = d.Sync()
 		}
-
-		_, err = d.Write(line)
+		d.Close()
 		if err != nil {
-			d.Close()
 			return errors.Wrap(err, "Cannot write certificate")
 		}
+	}
 
-		if bytes.Contains(line, []byte("END CERTIFICATE")) {
-			_ = d.Sync()
-			d.Close()
-			d = nil
-			certNum++
+	opts.refreshCATrust(refreshCommand)
+	return nil
+}
+
+// refreshCATrust runs the command refreshing the system certificate store,
+// so that the system trusts the certificates installed in the local trust.
+// A failure is only a warning, as the client still trusts the server through
+// its ServerCertificate.
+func (opts *setupOptionsType) refreshCATrust(command []string) {
+	if opts.skipCARefresh {
+		log.Info("Not refreshing the system certificate store")
+		return
+	}
+	output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+	out := strings.TrimSpace(string(output))
+	if err != nil {
+		opts.warnings.warnf("Cannot refresh the system certificate store: "+
+			"%s failed: %v %s", command[0], err, out)
+		return
+	}
+	if out != "" {
+		log.Infof("%s: %s", command[0], out)
+	}
+}
+
+// Layouts of the system certificate store, for --trust-style.
+const (
+	trustStyleDebian = "debian" // update-ca-certificates, e.g. Debian, Ubuntu
+	trustStyleRedhat = "redhat" // update-ca-trust, e.g. Fedora, RHEL
+)
+
+// localTrust returns the directory the demo certificate is installed in,
+// and the command which refreshes the system certificate store from it.
+func (opts *setupOptionsType) localTrust() (string, []string) {
+	style := opts.trustStyle
+	if style == "" {
+		style = detectTrustStyle(DefaultOSReleaseFile)
+	}
+	if style == trustStyleRedhat {
+		return DefaultRedhatLocalTrustDir, []string{updateCATrustBinary, "extract"}
+	}
+	return DefaultLocalTrustMenderDir, []string{updateCACertificatesBinary}
+}
+
+// detectTrustStyle returns the trust style of the distribution family named
+// by ID or ID_LIKE in the os-release file, and the Debian style otherwise.
+func detectTrustStyle(osReleaseFile string) string {
+	for _, field := range []string{"ID", "ID_LIKE"} {
+		value, _ := getOSReleaseField(field, osReleaseFile)
+		for _, id := range strings.Fields(value) {
+			switch id {
+			case "fedora", "rhel", "centos":
+				return trustStyleRedhat
+			}
 		}
 	}
+	return trustStyleDebian
+}
 
-	// cmd := system.Command("update-ca-certificates")
-	// out, err := cmd.CombinedOutput()
+// splitCertificateChain splits a PEM file into one block per certificate,
+// each ending with a newline, for update-ca-certificates, which expects a
+// single certificate per file. Blank lines after the last certificate are
+// ignored, but every block must parse as a certificate.
+func splitCertificateChain(data []byte) ([][]byte, error) {
+	var certs [][]byte
+	var block []byte
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes(byte('\n'))
+		if err != nil && errors.Cause(err) != io.EOF {
+			return nil, errors.Wrap(err, "Cannot read certificate")
+		}
+		block = append(block, line...)
+		if bytes.Contains(line, []byte("END CERTIFICATE")) {
+			if !bytes.HasSuffix(block, []byte("\n")) {
+				block = append(block, '\n')
+			}
+			if !isPEMCertificates(block) {
+				return nil, errors.Errorf(
+					"Certificate %d cannot be parsed", len(certs)+1)
+			}
+			certs = append(certs, block)
+			block = nil
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(bytes.TrimSpace(block)) > 0 {
+		return nil, errors.Errorf(
+			"Unexpected data after certificate %d", len(certs))
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificates found")
+	}
+	return certs, nil
+}
 
-	// if err != nil {
-	// 	return errors.Wrapf(err,
-	// 		"update-ca-certificates returned %q", out)
-	// }
+// backupConfigFile copies an existing configuration file to <filePath>.bak,
+// readable only by the owner like the configuration itself.
+func backupConfigFile(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "Error reading the configuration to back up")
+	}
+	backupPath := filePath + ".bak"
+	if err := ioutil.WriteFile(backupPath, data, 0600); err != nil {
+		return errors.Wrap(err, "Error writing the configuration backup")
+	}
+	// WriteFile keeps the mode of an existing backup.
+	if err := os.Chmod(backupPath, 0600); err != nil {
+		return errors.Wrap(err, "Error writing the configuration backup")
+	}
+	log.Infof("Backed up the previous configuration to %s", backupPath)
+	return nil
+}
 
+// writeChecksumFile writes the SHA-256 digest of the file at filePath to
+// <filePath>.sha256, in the format understood by `sha256sum --check`.
+func writeChecksumFile(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return errors.Wrap(err, "Error reading the file to checksum")
+	}
+	digest := sha256.Sum256(data)
+	line := fmt.Sprintf("%x  %s\n", digest, path.Base(filePath))
+	if err := ioutil.WriteFile(
+		filePath+".sha256", []byte(line), 0644); err != nil {
+		return errors.Wrap(err, "Error writing the checksum file")
+	}
 	return nil
 }
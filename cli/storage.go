@@ -0,0 +1,46 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Magic numbers of the volatile file systems, see statfs(2).
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+var volatileFileSystems = map[int64]string{
+	tmpfsMagic: "tmpfs",
+	ramfsMagic: "ramfs",
+}
+
+// checkPersistentStorage returns an error if any of dirs is on a file
+// system which does not survive a reboot.
+func checkPersistentStorage(dirs ...string) error {
+	for _, dir := range dirs {
+		fsType, err := fileSystemType(dir)
+		if err != nil {
+			return errors.Wrapf(err,
+				"Cannot determine the file system of %q", dir)
+		}
+		if name, ok := volatileFileSystems[fsType]; ok {
+			return errors.Errorf("%q is on a %s file system, which does "+
+				"not persist across reboots", dir, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPersistentStorage(t *testing.T) {
+	oldFileSystemType := fileSystemType
+	defer func() {
+		fileSystemType = oldFileSystemType
+	}()
+
+	const ext4Magic = 0xef53
+	fileSystemType = func(dir string) (int64, error) {
+		if dir == "/run/mender" {
+			return tmpfsMagic, nil
+		}
+		return ext4Magic, nil
+	}
+	assert.NoError(t, checkPersistentStorage("/etc/mender", "/var/lib/mender"))
+	err := checkPersistentStorage("/etc/mender", "/run/mender")
+	assert.EqualError(t, err, `"/run/mender" is on a tmpfs file system, `+
+		`which does not persist across reboots`)
+
+	// The real lookup works on an existing directory, where the file
+	// system types are known.
+	fileSystemType = oldFileSystemType
+	_, err = fileSystemType(".")
+	if runtime.GOOS == "linux" {
+		assert.NoError(t, err)
+	} else {
+		assert.Error(t, err)
+	}
+}
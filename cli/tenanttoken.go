@@ -0,0 +1,117 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// hostedMenderDomain is the host of the default Hosted Mender region;
+	// the other regions are its subdomains, e.g. eu.hosted.mender.io.
+	hostedMenderDomain        = "hosted.mender.io"
+	hostedMenderDefaultRegion = "us"
+)
+
+// hostedMenderRegion returns the region of a Hosted Mender host, or "" if
+// host is not Hosted Mender.
+func hostedMenderRegion(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == hostedMenderDomain {
+		return hostedMenderDefaultRegion
+	}
+	region := strings.TrimSuffix(host, "."+hostedMenderDomain)
+	if region == host || region == "" || strings.Contains(region, ".") {
+		return ""
+	}
+	return region
+}
+
+// tenantTokenClaims are the claims of a tenant token which tell the region
+// it was issued for.
+type tenantTokenClaims struct {
+	Issuer string `json:"iss"`
+	Region string `json:"mender.region"`
+}
+
+// decodeTenantTokenClaims decodes the claims of a tenant token, which is a
+// JWT. The signature is not verified; only the server can do that.
+func decodeTenantTokenClaims(token string) (*tenantTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the tenant token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(
+		strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid tenant token payload")
+	}
+	claims := &tenantTokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, errors.Wrap(err, "Invalid tenant token claims")
+	}
+	return claims, nil
+}
+
+// region returns the Hosted Mender region the token was issued for: the
+// mender.region claim, or else the region of an issuer which is a Hosted
+// Mender host. It is "" if the token does not tell.
+func (claims *tenantTokenClaims) region() string {
+	if claims.Region != "" {
+		return strings.ToLower(claims.Region)
+	}
+	issuer := claims.Issuer
+	if u, err := url.Parse(issuer); err == nil && u.Host != "" {
+		issuer = u.Hostname()
+	}
+	return hostedMenderRegion(issuer)
+}
+
+// checkTenantTokenRegion warns when the tenant token was issued for another
+// Hosted Mender region than the one of the server, such as a token of the
+// EU region used with hosted.mender.io, as the device would never be
+// accepted. The check is best effort: tokens which do not tell their region
+// are not checked.
+func (opts *setupOptionsType) checkTenantTokenRegion() {
+	if opts.tenantToken == "" {
+		return
+	}
+	u, err := url.Parse(opts.serverURL)
+	if err != nil {
+		return
+	}
+	serverRegion := hostedMenderRegion(u.Hostname())
+	if serverRegion == "" {
+		return
+	}
+	claims, err := decodeTenantTokenClaims(opts.tenantToken)
+	if err != nil {
+		log.Debugf("Not checking the region of the tenant token: %v", err)
+		return
+	}
+	tokenRegion := claims.region()
+	if tokenRegion == "" || tokenRegion == serverRegion {
+		return
+	}
+	opts.warnings.warnf("The tenant token was issued for the Hosted Mender "+
+		"region %q, but the server %s is in the region %q; the device will "+
+		"not be accepted with this token", tokenRegion, opts.serverURL,
+		serverRegion)
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestTenantToken returns an unsigned JWT with the given claims.
+func newTestTenantToken(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." +
+		encode([]byte(claims)) + ".c2lnbmF0dXJl"
+}
+
+func TestHostedMenderRegion(t *testing.T) {
+	assert.Equal(t, "us", hostedMenderRegion("hosted.mender.io"))
+	assert.Equal(t, "eu", hostedMenderRegion("EU.hosted.mender.io"))
+	assert.Equal(t, "", hostedMenderRegion("a.b.hosted.mender.io"))
+	assert.Equal(t, "", hostedMenderRegion("myhosted.mender.io"))
+	assert.Equal(t, "", hostedMenderRegion("acme.mender.io"))
+}
+
+func TestCheckTenantTokenRegion(t *testing.T) {
+	for _, tc := range []struct {
+		serverURL string
+		token     string
+		warning   string
+	}{
+		{
+			serverURL: hostedMenderURL,
+			token: newTestTenantToken(
+				`{"iss":"Mender","mender.tenant":"1","mender.region":"eu"}`),
+			warning: `The tenant token was issued for the Hosted Mender ` +
+				`region "eu", but the server https://hosted.mender.io is ` +
+				`in the region "us"`,
+		},
+		{
+			serverURL: "https://eu.hosted.mender.io",
+			token:     newTestTenantToken(`{"iss":"https://hosted.mender.io"}`),
+			warning:   `region "us", but the server https://eu.hosted.mender.io`,
+		},
+		{
+			serverURL: "https://eu.hosted.mender.io",
+			token:     newTestTenantToken(`{"mender.region":"EU"}`),
+		},
+		{
+			// Tokens which do not tell their region are not checked.
+			serverURL: hostedMenderURL,
+			token:     newTestTenantToken(`{"iss":"Mender"}`),
+		},
+		{
+			serverURL: hostedMenderURL,
+			token:     "dummy-token",
+		},
+		{
+			serverURL: "https://acme.mender.io",
+			token:     newTestTenantToken(`{"mender.region":"eu"}`),
+		},
+	} {
+		opts := &setupOptionsType{
+			serverURL:   tc.serverURL,
+			tenantToken: tc.token,
+			warnings:    newWarningSink(),
+		}
+		opts.checkTenantTokenRegion()
+		if tc.warning == "" {
+			assert.Empty(t, opts.warnings.collected(), tc.token)
+			continue
+		}
+		if assert.Len(t, opts.warnings.collected(), 1, tc.token) {
+			assert.Contains(t, opts.warnings.collected()[0], tc.warning)
+		}
+	}
+}
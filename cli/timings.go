@@ -0,0 +1,112 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Phase names used in the timing report.
+const (
+	phaseConfigLoad      = "config-load"
+	phasePrompts         = "prompts"
+	phaseLogin           = "login"
+	phaseTokenFetch      = "token-fetch"
+	phaseConfigWrite     = "config-write"
+	phaseDeviceTypeWrite = "device-type-write"
+	phaseHostsUpdate     = "hosts-update"
+	phaseCertInstall     = "cert-install"
+)
+
+type phaseTiming struct {
+	Phase    string  `json:"phase"`
+	Seconds  float64 `json:"seconds"`
+	duration time.Duration
+}
+
+// phaseTimings records how long each phase of a setup run takes. A nil
+// *phaseTimings is valid and records nothing, so callers need not check
+// whether --timings was given.
+type phaseTimings struct {
+	started time.Time
+	phases  []phaseTiming
+}
+
+func newPhaseTimings() *phaseTimings {
+	return &phaseTimings{started: time.Now()}
+}
+
+// start begins timing the given phase and returns the function which ends
+// it, e.g.:
+//
+//	defer opts.timings.start(phaseConfigWrite)()
+func (t *phaseTimings) start(phase string) func() {
+	if t == nil {
+		return func() {}
+	}
+	begin := time.Now()
+	return func() {
+		d := time.Since(begin)
+		t.phases = append(t.phases, phaseTiming{
+			Phase:    phase,
+			Seconds:  d.Seconds(),
+			duration: d,
+		})
+	}
+}
+
+// total is the wall-clock time since the timings were created. Phases may
+// nest (logging in happens while prompting), so summing them would count
+// some time twice.
+func (t *phaseTimings) total() time.Duration {
+	return time.Since(t.started)
+}
+
+// writeReport prints the recorded phases in the order they completed,
+// either as aligned text or as a JSON document. The JSON document also
+// carries the warnings collected with --warnings-summary.
+func (t *phaseTimings) writeReport(w io.Writer, asJSON bool,
+	warnings []string) error {
+	if t == nil {
+		return nil
+	}
+	if asJSON {
+		report := struct {
+			Phases       []phaseTiming `json:"phases"`
+			TotalSeconds float64       `json:"total_seconds"`
+			Warnings     []string      `json:"warnings,omitempty"`
+		}{
+			Phases:       t.phases,
+			TotalSeconds: t.total().Seconds(),
+			Warnings:     warnings,
+		}
+		if report.Phases == nil {
+			report.Phases = []phaseTiming{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return errors.Wrap(enc.Encode(report), "Error encoding timing report")
+	}
+	fmt.Fprintln(w, "Setup timings:")
+	for _, p := range t.phases {
+		fmt.Fprintf(w, "  %-18s %s\n", p.Phase, p.duration)
+	}
+	fmt.Fprintf(w, "  %-18s %s\n", "total", t.total())
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupTimingsReport(t *testing.T) {
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.timings = newPhaseTimings()
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "https://acme.mender.io")
+	opts.serverURL = "https://acme.mender.io"
+	ctx.Set("server-cert", "")
+	err := doSetup(ctx, config, opts)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, opts.timings.writeReport(&buf, true, nil))
+	var report struct {
+		Phases []struct {
+			Phase   string  `json:"phase"`
+			Seconds float64 `json:"seconds"`
+		} `json:"phases"`
+		TotalSeconds float64 `json:"total_seconds"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	var phases []string
+	for _, p := range report.Phases {
+		phases = append(phases, p.Phase)
+		assert.GreaterOrEqual(t, p.Seconds, 0.0)
+	}
+	assert.Equal(t,
+		[]string{phasePrompts, phaseConfigWrite, phaseDeviceTypeWrite},
+		phases)
+	assert.Greater(t, report.TotalSeconds, 0.0)
+
+	buf.Reset()
+	require.NoError(t, opts.timings.writeReport(&buf, false, nil))
+	assert.Contains(t, buf.String(), phaseConfigWrite)
+	assert.Contains(t, buf.String(), "total")
+
+	// Disabled timings record and print nothing.
+	var disabled *phaseTimings
+	disabled.start(phasePrompts)()
+	buf.Reset()
+	require.NoError(t, disabled.writeReport(&buf, true, nil))
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// needed so that we can override it when testing
+var menderClientBinary = "mender"
+
+// verifyWithClient has the mender client load the configuration at
+// configPath, to catch settings it does not accept which setup cannot know
+// about. It is skipped if the client is not installed.
+func verifyWithClient(configPath string) error {
+	bin, err := exec.LookPath(menderClientBinary)
+	if err != nil {
+		log.Infof("Not verifying the configuration: the mender client "+
+			"(%s) was not found", menderClientBinary)
+		return nil
+	}
+	output, err := exec.Command(
+		bin, "--config", configPath, "show-artifact").CombinedOutput()
+	if err != nil {
+		return errors.Errorf("The mender client rejected the "+
+			"configuration %s: %s", configPath,
+			strings.TrimSpace(string(output)))
+	}
+	log.Infof("The mender client accepts the configuration %s", configPath)
+	return nil
+}
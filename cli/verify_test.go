@@ -0,0 +1,55 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWithClient(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	oldMenderClientBinary := menderClientBinary
+	defer func() {
+		menderClientBinary = oldMenderClientBinary
+	}()
+
+	// The stub client accepts only configurations named good.conf.
+	menderClientBinary = path.Join(tdir, "mender")
+	require.NoError(t, ioutil.WriteFile(menderClientBinary, []byte(
+		"#!/bin/sh\n"+
+			"[ \"$1\" = --config ] && [ \"$3\" = show-artifact ] || exit 2\n"+
+			"case \"$2\" in */good.conf) echo artifact-1; exit 0;; esac\n"+
+			"echo \"failed to load config: unknown field\" >&2\n"+
+			"exit 1\n"), 0755))
+
+	assert.NoError(t, verifyWithClient(path.Join(tdir, "good.conf")))
+
+	err = verifyWithClient(path.Join(tdir, "bad.conf"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rejected")
+	assert.Contains(t, err.Error(), "unknown field")
+
+	// Without a client the check is skipped.
+	menderClientBinary = path.Join(tdir, "missing")
+	assert.NoError(t, verifyWithClient(path.Join(tdir, "bad.conf")))
+}
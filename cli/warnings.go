@@ -0,0 +1,64 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// warningSink receives the warnings of a setup run. A nil *warningSink is
+// valid and logs each warning as it happens; with --warnings-summary the
+// warnings are instead collected and printed together at the end, so that
+// they are not lost between the prompts.
+type warningSink struct {
+	warnings []string
+}
+
+func newWarningSink() *warningSink {
+	return &warningSink{}
+}
+
+func (s *warningSink) warn(msg string) {
+	if s == nil {
+		log.Warn(msg)
+		return
+	}
+	s.warnings = append(s.warnings, msg)
+}
+
+func (s *warningSink) warnf(format string, args ...interface{}) {
+	s.warn(fmt.Sprintf(format, args...))
+}
+
+// collected returns the warnings so far, or nil if they are logged inline.
+func (s *warningSink) collected() []string {
+	if s == nil {
+		return nil
+	}
+	return s.warnings
+}
+
+// writeSummary prints the collected warnings, if there are any.
+func (s *warningSink) writeSummary(w io.Writer) {
+	if len(s.collected()) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Setup finished with %d warning(s):\n", len(s.warnings))
+	for _, msg := range s.warnings {
+		fmt.Fprintf(w, "  - %s\n", msg)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarningsSummary(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	flagSet := newFlagSet()
+	ctx, config, runOptions := initCLITest(t, flagSet)
+	defer os.RemoveAll(path.Dir(runOptions.setupOptions.configPath))
+	opts := &runOptions.setupOptions
+	opts.warnings = newWarningSink()
+	opts.timings = newPhaseTimings()
+
+	// A format not matching the extension, and a plain http server URL.
+	opts.configFormat = "yaml"
+	require.NoError(t, opts.checkConfigFormat())
+
+	ctx.Set("device-type", "acme-pi")
+	opts.deviceType = "acme-pi"
+	ctx.Set("hosted-mender", "false")
+	ctx.Set("demo-server", "false")
+	ctx.Set("demo-polling", "true")
+	opts.demoIntervals = true
+	ctx.Set("server-url", "http://acme.mender.io")
+	opts.serverURL = "http://acme.mender.io"
+	ctx.Set("server-cert", "")
+	require.NoError(t, doSetup(ctx, config, opts))
+
+	// Nothing is logged inline...
+	assert.NotContains(t, logBuf.String(), "level=warning")
+
+	// ...but both warnings are in the summary, and in the JSON report.
+	var summary bytes.Buffer
+	opts.warnings.writeSummary(&summary)
+	assert.Contains(t, summary.String(), "2 warning(s)")
+	assert.Contains(t, summary.String(), "does not match the extension")
+	assert.Contains(t, summary.String(), "uses plain http")
+
+	var buf bytes.Buffer
+	require.NoError(t, opts.timings.writeReport(&buf, true,
+		opts.warnings.collected()))
+	var report struct {
+		Warnings []string `json:"warnings"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, opts.warnings.collected(), report.Warnings)
+	assert.Len(t, report.Warnings, 2)
+}
+
+func TestWarningsInline(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	var sink *warningSink
+	sink.warnf("something %s", "odd")
+	assert.Contains(t, logBuf.String(), "something odd")
+	assert.Nil(t, sink.collected())
+
+	var summary bytes.Buffer
+	sink.writeSummary(&summary)
+	assert.Empty(t, summary.String())
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+//go:build linux
+// +build linux
+
+package cli
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// xattrsSupported tells whether --preserve-acls can be used on this
+// platform.
+const xattrsSupported = true
+
+// fileXattrs are the extended attributes of a file, which hold its POSIX
+// ACLs (system.posix_acl_access) and SELinux context (security.selinux),
+// among others. Replacing a file by renaming a new one over it drops them,
+// so with --preserve-acls they are read before and restored after writing.
+type fileXattrs map[string][]byte
+
+// readXattrs returns the extended attributes of the file at path, or none
+// if it does not exist or the file system does not support them.
+func readXattrs(path string) (fileXattrs, error) {
+	size, err := unix.Listxattr(path, nil)
+	if os.IsNotExist(err) || err == unix.ENOTSUP {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err,
+			"Cannot list the extended attributes of %s", path)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	names := make([]byte, size)
+	size, err = unix.Listxattr(path, names)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"Cannot list the extended attributes of %s", path)
+	}
+	xattrs := fileXattrs{}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"Cannot read the extended attribute %s of %s", name, path)
+	}
+	value := make([]byte, size)
+	size, err = unix.Getxattr(path, name, value)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"Cannot read the extended attribute %s of %s", name, path)
+	}
+	return value[:size], nil
+}
+
+// restore sets the extended attributes on the file at path.
+func (xattrs fileXattrs) restore(path string) error {
+	for name, value := range xattrs {
+		if err := unix.Setxattr(path, name, value, 0); err != nil {
+			return errors.Wrapf(err,
+				"Cannot restore the extended attribute %s of %s", name, path)
+		}
+	}
+	return nil
+}
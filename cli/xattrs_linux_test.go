@@ -0,0 +1,79 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+//go:build linux
+// +build linux
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetupPreserveACLs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	defer log.SetLevel(log.GetLevel())
+	confPath := path.Join(tmpDir, "mender.conf")
+
+	const selinuxContext = "system_u:object_r:etc_t:s0"
+	seed := func() {
+		require.NoError(t, ioutil.WriteFile(confPath, []byte("{}"), 0600))
+		for name, value := range map[string]string{
+			"security.selinux": selinuxContext,
+			"user.mender":      "kept",
+		} {
+			err := unix.Setxattr(confPath, name, []byte(value), 0)
+			if err != nil {
+				t.Skipf("Cannot set the extended attribute %s here: %v",
+					name, err)
+			}
+		}
+	}
+	args := []string{"mender-setup", "--quiet", "--no-backup",
+		"--config", confPath, "--data", tmpDir,
+		"--device-type", "acme-pi", "--server-url", "https://acme.mender.io",
+		"--server-cert", "", "--demo-polling"}
+
+	seed()
+	require.NoError(t, SetupCLI(append(args, "--preserve-acls")))
+	xattrs, err := readXattrs(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, selinuxContext, string(xattrs["security.selinux"]))
+	assert.Equal(t, "kept", string(xattrs["user.mender"]))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "https://acme.mender.io")
+
+	// Without it, the rewritten file has the attributes of a new one.
+	seed()
+	require.NoError(t, SetupCLI(args))
+	xattrs, err = readXattrs(confPath)
+	require.NoError(t, err)
+	assert.NotContains(t, xattrs, "user.mender")
+
+	// A file which does not exist yet has none to keep.
+	xattrs, err = readXattrs(path.Join(tmpDir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, xattrs)
+}
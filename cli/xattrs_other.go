@@ -0,0 +1,34 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package cli
+
+// xattrsSupported tells whether --preserve-acls can be used on this
+// platform.
+const xattrsSupported = false
+
+// fileXattrs stands in for the extended attributes of a file, which setup
+// only preserves on Linux.
+type fileXattrs map[string][]byte
+
+func readXattrs(path string) (fileXattrs, error) {
+	return nil, nil
+}
+
+func (xattrs fileXattrs) restore(path string) error {
+	return nil
+}
@@ -14,9 +14,15 @@
 package conf
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -31,8 +37,13 @@ const (
 // given in MenderConfig.
 type MenderServer struct {
 	ServerURL string
+	// ServerCertificate and TenantToken are only set here when the
+	// configuration is written in the per-server style; by default they
+	// are at the top level, where all clients look for them.
+	ServerCertificate string `json:",omitempty"`
+	TenantToken       string `json:",omitempty"`
 	// TODO: Move all possible server specific configurations in
-	//       MenderConfig over to this struct. (e.g. TenantToken?)
+	//       MenderConfig over to this struct.
 }
 
 type Security struct {
@@ -78,9 +89,16 @@ type MenderConfigFromFile struct {
 	UpdatePollIntervalSeconds int `json:",omitempty"`
 	// Poll interval for periodically sending inventory data
 	InventoryPollIntervalSeconds int `json:",omitempty"`
+	// Percentage by which the update and inventory polls are randomly
+	// spread, so that a fleet does not poll at the same time. EXPERIMENTAL:
+	// not yet read by the client.
+	PollJitterPercent int `json:",omitempty"`
 
 	// Skip CA certificate validation
 	SkipVerify bool `json:",omitempty"`
+	// Minimum TLS version to negotiate with the server ("1.0", "1.1",
+	// "1.2" or "1.3")
+	MinTLSVersion string `json:",omitempty"`
 
 	// Global retry polling max interval for fetching update, authorize wait and update status
 	RetryPollIntervalSeconds int `json:",omitempty"`
@@ -111,6 +129,10 @@ type MenderConfigFromFile struct {
 	Servers []MenderServer `json:",omitempty"`
 	// Log level which takes effect right before daemon startup
 	DaemonLogLevel string `json:",omitempty"`
+
+	// Top-level fields not known to this version, such as options of a
+	// newer client, kept as they are when the configuration is saved.
+	UnknownFields map[string]json.RawMessage `json:"-"`
 }
 
 // HttpsClient holds the configuration for the client side mTLS configuration
@@ -187,6 +209,7 @@ func LoadConfig(mainConfigFile string, fallbackConfigFile string) (*MenderConfig
 
 	log.Debugf("Loaded %d configuration file(s)", filesLoadedCount)
 
+	migrateServerURL(&config.MenderConfigFromFile)
 	checkConfigDefaults(config)
 
 	if filesLoadedCount == 0 {
@@ -212,18 +235,59 @@ func loadConfigFile(configFile string, config *MenderConfig, filesLoadedCount *i
 		return err
 	}
 
+	if err := unifyArtifactVerifyKeys(config); err != nil {
+		return err
+	}
+
+	(*filesLoadedCount)++
+	log.Info("Loaded configuration file: ", configFile)
+	return nil
+}
+
+// LoadConfigReader loads a single configuration, in the given format, from
+// r; e.g. for reading it from stdin.
+func LoadConfigReader(r io.Reader, format string) (*MenderConfig, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading configuration")
+	}
+	config := NewMenderConfig()
+	if err := decodeConfig(&config.MenderConfigFromFile, data, format); err != nil {
+		return nil, err
+	}
+	if err := unifyArtifactVerifyKeys(config); err != nil {
+		return nil, err
+	}
+	migrateServerURL(&config.MenderConfigFromFile)
+	checkConfigDefaults(config)
+	return config, nil
+}
+
+// ErrArtifactVerifyKeysConflict is returned when loading a configuration
+// with both ArtifactVerifyKey and ArtifactVerifyKeys.
+var ErrArtifactVerifyKeysConflict = errors.New(
+	"both ArtifactVerifyKey and ArtifactVerifyKeys are set")
+
+// CheckArtifactVerifyKeys returns ErrArtifactVerifyKeysConflict if both a
+// single key and a list of keys are given.
+func CheckArtifactVerifyKeys(key string, keys []string) error {
+	if key != "" && len(keys) > 0 {
+		return ErrArtifactVerifyKeysConflict
+	}
+	return nil
+}
+
+func unifyArtifactVerifyKeys(config *MenderConfig) error {
+	if err := CheckArtifactVerifyKeys(
+		config.ArtifactVerifyKey, config.ArtifactVerifyKeys); err != nil {
+		return err
+	}
 	if config.ArtifactVerifyKey != "" {
-		if len(config.ArtifactVerifyKeys) > 0 {
-			return errors.New("both ArtifactVerifyKey and ArtifactVerifyKeys are set")
-		}
 		// Unify the logic for verification key processing by moving
 		// the single ArtifactVerifyKey to the list version.
 		config.ArtifactVerifyKeys = append(config.ArtifactVerifyKeys, config.ArtifactVerifyKey)
 		config.ArtifactVerifyKey = ""
 	}
-
-	(*filesLoadedCount)++
-	log.Info("Loaded configuration file: ", configFile)
 	return nil
 }
 
@@ -235,18 +299,114 @@ func readConfigFile(config interface{}, fileName string) error {
 	if err != nil {
 		return err
 	}
+	return decodeConfig(config, conf, FormatFromExtension(fileName))
+}
+
+func decodeConfig(config interface{}, conf []byte, format string) error {
+	var err error
+	if format == FormatYAML {
+		if conf, err = yamlToJSON(conf); err != nil {
+			return errors.New("Error parsing config file: " + err.Error())
+		}
+	}
 
 	if err := json.Unmarshal(conf, &config); err != nil {
-		switch err.(type) {
+		switch err := err.(type) {
 		case *json.SyntaxError:
-			return errors.New("Error parsing mender configuration file: " + err.Error())
+			return errors.New("Error parsing mender configuration file: " +
+				err.Error() + " " + describeJSONOffset(conf, err.Offset))
+		case *json.UnmarshalTypeError:
+			return errors.New("Error parsing config file: " +
+				err.Error() + " " + describeJSONOffset(conf, err.Offset))
 		}
 		return errors.New("Error parsing config file: " + err.Error())
 	}
 
+	if config, ok := config.(*MenderConfigFromFile); ok {
+		return collectUnknownFields(config, conf)
+	}
+	return nil
+}
+
+// collectUnknownFields adds the top-level fields in data which do not match a
+// field of MenderConfigFromFile to config.UnknownFields, so that saving the
+// configuration does not drop them.
+func collectUnknownFields(config *MenderConfigFromFile, data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return errors.New("Error parsing config file: " + err.Error())
+	}
+	for key, value := range fields {
+		if isKnownConfigField(key) {
+			continue
+		}
+		if config.UnknownFields == nil {
+			config.UnknownFields = map[string]json.RawMessage{}
+		}
+		config.UnknownFields[key] = value
+	}
 	return nil
 }
 
+// isKnownConfigField tells whether encoding/json decodes key into a field of
+// MenderConfigFromFile, which it matches regardless of case.
+func isKnownConfigField(key string) bool {
+	configType := reflect.TypeOf(MenderConfigFromFile{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeJSONOffset translates an offset reported by encoding/json, which
+// is the number of bytes read when the error was detected, into a line and
+// column, followed by the offending line with a marker under the column.
+func describeJSONOffset(data []byte, offset int64) string {
+	pos := int(offset) - 1
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= len(data) {
+		pos = len(data) - 1
+	}
+	if pos < 0 {
+		return "(at the start of the file)"
+	}
+	lineStart := bytes.LastIndexByte(data[:pos], '\n') + 1
+	lineEnd := bytes.IndexByte(data[pos:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(data)
+	} else {
+		lineEnd += pos
+	}
+	line := bytes.Count(data[:pos], []byte("\n")) + 1
+	column := pos - lineStart + 1
+	snippet := strings.TrimRight(string(data[lineStart:lineEnd]), "\r")
+	return fmt.Sprintf("(line %d, column %d):\n    %s\n    %s^",
+		line, column, snippet, strings.Repeat(" ", column-1))
+}
+
+// migrateServerURL moves a legacy ServerURL into Servers when that is
+// empty, so that saving the configuration again keeps the server.
+func migrateServerURL(config *MenderConfigFromFile) {
+	if config.ServerURL == "" || len(config.Servers) > 0 {
+		return
+	}
+	log.Infof("Migrating the legacy ServerURL %q to Servers", config.ServerURL)
+	config.Servers = []MenderServer{{ServerURL: config.ServerURL}}
+	config.ServerURL = ""
+}
+
 func checkConfigDefaults(config *MenderConfig) {
 	if config.MenderConfigFromFile.UpdateControlMapExpirationTimeSeconds == 0 {
 		log.Info(
@@ -265,23 +425,134 @@ func checkConfigDefaults(config *MenderConfig) {
 	}
 }
 
+// CanonicalizeConfig rewrites config into the canonical form which setup
+// produces, without changing what it means to the client: a single
+// ArtifactVerifyKey is moved to ArtifactVerifyKeys, a legacy ServerURL is
+// moved to the front of Servers, and trailing slashes are trimmed from
+// server URLs.
+func CanonicalizeConfig(config *MenderConfigFromFile) {
+	if config.ArtifactVerifyKey != "" && len(config.ArtifactVerifyKeys) == 0 {
+		config.ArtifactVerifyKeys = []string{config.ArtifactVerifyKey}
+		config.ArtifactVerifyKey = ""
+	}
+
+	if config.ServerURL != "" {
+		serverURL := strings.TrimRight(config.ServerURL, "/")
+		found := false
+		for _, server := range config.Servers {
+			if strings.TrimRight(server.ServerURL, "/") == serverURL {
+				found = true
+				break
+			}
+		}
+		if !found {
+			config.Servers = append(
+				[]MenderServer{{ServerURL: serverURL}}, config.Servers...)
+		}
+		config.ServerURL = ""
+	}
+	for i := range config.Servers {
+		config.Servers[i].ServerURL = strings.TrimRight(
+			config.Servers[i].ServerURL, "/")
+	}
+}
+
 func SaveConfigFile(config *MenderConfigFromFile, filename string) error {
-	configJson, err := json.MarshalIndent(config, "", "    ")
+	return SaveConfigFileFormat(config, filename, FormatJSON)
+}
+
+// SaveConfigFileFormat is like SaveConfigFile, but encodes the configuration
+// in the given format.
+func SaveConfigFileFormat(config *MenderConfigFromFile, filename, format string) error {
+	configData, err := MarshalConfig(config, format)
+	if err != nil {
+		return err
+	}
+	return writeConfigData(configData, filename)
+}
+
+// SaveConfigFileSortedKeys is like SaveConfigFileFormat, but writes the keys
+// in alphabetical order; see MarshalConfigSortedKeys.
+func SaveConfigFileSortedKeys(
+	config *MenderConfigFromFile, filename, format string) error {
+	configData, err := MarshalConfigSortedKeys(config, format)
 	if err != nil {
-		return errors.Wrap(err, "Error encoding configuration to JSON")
+		return err
 	}
-	f, err := os.OpenFile(
-		filename,
-		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-		0600,
-	) // for mode see MEN-3762
+	return writeConfigData(configData, filename)
+}
+
+// SetConfigFileFields sets the given top-level fields in the existing
+// configuration file, leaving all other fields, including those unknown to
+// MenderConfigFromFile, as they are. The keys are written in alphabetical
+// order.
+func SetConfigFileFields(
+	filename, format string, fields map[string]interface{}) error {
+	configData, err := MarshalConfigFileFields(filename, format, fields)
 	if err != nil {
-		return errors.Wrap(err, "Error opening configuration file")
+		return err
+	}
+	return writeConfigData(configData, filename)
+}
+
+// MarshalConfigFileFields returns what SetConfigFileFields would write,
+// without writing it.
+func MarshalConfigFileFields(
+	filename, format string, fields map[string]interface{}) ([]byte, error) {
+	var config map[string]interface{}
+	if err := readConfigFile(&config, filename); err != nil {
+		return nil, errors.Wrapf(err,
+			"Cannot read configuration file %q", filename)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
 	}
-	defer f.Close()
+	for key, value := range fields {
+		config[key] = value
+	}
+	return marshalFormat(config, format)
+}
 
-	if _, err = f.Write(configJson); err != nil {
+func writeConfigData(configData []byte, filename string) error {
+	// for mode see MEN-3762
+	if err := WriteFileAtomic(filename, configData, 0600); err != nil {
 		return errors.Wrap(err, "Error writing to configuration file")
 	}
 	return nil
 }
+
+// WriteFileAtomic writes data to filename with the given mode through a
+// temporary file in the same directory, which is renamed over filename, so
+// that an interrupted write leaves either the old or the new content, never
+// a partial file. If filename is a symlink, its target is replaced.
+func WriteFileAtomic(filename string, data []byte, mode os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName)
+	// The temporary file is created 0600, and Chmod is not subject to the
+	// umask as the mode given to OpenFile would be.
+	err = f.Chmod(mode)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestConfig writes content as mender.conf in a new temporary
+// directory, which the caller must remove.
+func writeTestConfig(t *testing.T, content string) string {
+	tmpDir, err := ioutil.TempDir("", "conftest")
+	require.NoError(t, err)
+	confPath := path.Join(tmpDir, "mender.conf")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(content), 0600))
+	return confPath
+}
+
+func TestLoadConfigSyntaxErrorPosition(t *testing.T) {
+	confPath := writeTestConfig(t, "{\n"+
+		"    \"UpdatePollIntervalSeconds\": 1800\n"+
+		"    \"InventoryPollIntervalSeconds\": 28800\n"+
+		"}\n")
+	defer os.RemoveAll(path.Dir(confPath))
+
+	_, err := LoadConfig(confPath, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Error parsing mender configuration file")
+	assert.Contains(t, err.Error(), "(line 3, column 5)")
+	assert.Contains(t, err.Error(),
+		"\n        \"InventoryPollIntervalSeconds\": 28800\n        ^")
+}
+
+func TestLoadConfigTypeErrorPosition(t *testing.T) {
+	confPath := writeTestConfig(t,
+		`{"UpdatePollIntervalSeconds": "often"}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	_, err := LoadConfig(confPath, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "(line 1, column")
+}
+
+func TestLoadConfigMigratesServerURL(t *testing.T) {
+	confPath := writeTestConfig(t, `{"ServerURL": "https://legacy.mender.io"}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	config, err := LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, "", config.ServerURL)
+	assert.Equal(t, []MenderServer{{ServerURL: "https://legacy.mender.io"}},
+		config.Servers)
+
+	// With Servers present the ServerURL is left for the client to handle.
+	confPath2 := writeTestConfig(t, `{
+		"ServerURL": "https://legacy.mender.io",
+		"Servers": [{"ServerURL": "https://acme.mender.io"}]
+	}`)
+	defer os.RemoveAll(path.Dir(confPath2))
+
+	config, err = LoadConfig(confPath2, "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://legacy.mender.io", config.ServerURL)
+	assert.Equal(t, []MenderServer{{ServerURL: "https://acme.mender.io"}},
+		config.Servers)
+}
+
+func TestLoadConfigKeepsUnknownFields(t *testing.T) {
+	confPath := writeTestConfig(t, `{
+		"ServerCertificate": "/etc/mender/server.crt",
+		"tenanttoken": "token",
+		"FutureOption": {"Enabled": true, "Retries": 3},
+		"AnotherOption": "value"
+	}`)
+	defer os.RemoveAll(path.Dir(confPath))
+
+	config, err := LoadConfig(confPath, "")
+	require.NoError(t, err)
+	// Keys are matched to fields regardless of case, as encoding/json does.
+	assert.Equal(t, "token", config.TenantToken)
+	assert.Len(t, config.UnknownFields, 2)
+
+	require.NoError(t, SaveConfigFile(&config.MenderConfigFromFile, confPath))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"HttpsClient": {},
+		"Security": {},
+		"Connectivity": {},
+		"ServerCertificate": "/etc/mender/server.crt",
+		"TenantToken": "token",
+		"AnotherOption": "value",
+		"FutureOption": {"Enabled": true, "Retries": 3}
+	}`, string(data))
+
+	data, err = MarshalConfig(&config.MenderConfigFromFile, FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, "HttpsClient: {}\nSecurity: {}\nConnectivity: {}\n"+
+		"ServerCertificate: /etc/mender/server.crt\n"+
+		"TenantToken: token\n"+
+		"AnotherOption: value\n"+
+		"FutureOption:\n    Enabled: true\n    Retries: 3\n", string(data))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "conftest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// A new file gets the mode regardless of the umask...
+	confPath := path.Join(tmpDir, "mender.conf")
+	require.NoError(t, SaveConfigFile(&MenderConfigFromFile{
+		UpdatePollIntervalSeconds: 1800,
+	}, confPath))
+	info, err := os.Stat(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// ...and so does a replaced one.
+	require.NoError(t, os.Chmod(confPath, 0644))
+	require.NoError(t, WriteFileAtomic(confPath, []byte("{}"), 0600))
+	info, err = os.Stat(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+
+	// A symlink is kept, and its target replaced.
+	linkPath := path.Join(tmpDir, "link.conf")
+	require.NoError(t, os.Symlink(confPath, linkPath))
+	require.NoError(t, WriteFileAtomic(linkPath, []byte("[]"), 0600))
+	target, err := os.Readlink(linkPath)
+	require.NoError(t, err)
+	assert.Equal(t, confPath, target)
+	data, err = ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	assert.Error(t, WriteFileAtomic(path.Join(tmpDir, "missing", "mender.conf"),
+		[]byte("{}"), 0600))
+
+	// No temporary files are left behind.
+	entries, err := ioutil.ReadDir(tmpDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"mender.conf", "link.conf"}, names)
+}
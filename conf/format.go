@@ -0,0 +1,215 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported configuration file formats. The Mender client itself only reads
+// JSON; YAML is offered for authoring configurations.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// formatExtensions maps the file extensions we recognize to their format.
+// "mender.conf" is JSON.
+var formatExtensions = map[string]string{
+	".conf": FormatJSON,
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+}
+
+// ValidateFormat returns an error if format is not a supported format.
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatJSON, FormatYAML:
+		return nil
+	}
+	return errors.Errorf("Invalid configuration format %q: must be one of "+
+		"%s or %s", format, FormatJSON, FormatYAML)
+}
+
+// FormatFromExtension returns the format implied by the extension of
+// filename, or "" if the extension is not recognized.
+func FormatFromExtension(filename string) string {
+	return formatExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// FixExtension returns filename with an extension matching format: a
+// recognized extension of another format is replaced, and anything else has
+// the format's extension appended.
+func FixExtension(filename, format string) string {
+	ext := filepath.Ext(filename)
+	current := FormatFromExtension(filename)
+	if current == format {
+		return filename
+	}
+	if current != "" {
+		filename = strings.TrimSuffix(filename, ext)
+	}
+	return filename + "." + format
+}
+
+// MarshalConfig encodes config in the given format, using the same field
+// names and order for all formats. UnknownFields follow the known fields.
+func MarshalConfig(config *MenderConfigFromFile, format string) ([]byte, error) {
+	configJson, err := marshalConfigJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFormat(json.RawMessage(configJson), format)
+}
+
+// MarshalConfigSortedKeys is like MarshalConfig, but with the keys of all
+// objects in alphabetical order rather than in the order of the struct
+// fields, so that the output does not change when fields are reordered.
+func MarshalConfigSortedKeys(
+	config *MenderConfigFromFile, format string) ([]byte, error) {
+	configJson, err := marshalConfigJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	// Maps are encoded with sorted keys.
+	var sorted map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(configJson))
+	decoder.UseNumber()
+	if err := decoder.Decode(&sorted); err != nil {
+		return nil, errors.Wrap(err, "Error encoding configuration to JSON")
+	}
+	return marshalFormat(sorted, format)
+}
+
+// ConvertConfigFile writes the configuration in inFile, in the format implied
+// by its extension, to outFile in the given format. All fields, including
+// those unknown to MenderConfigFromFile, are kept with their values as they
+// are; the keys are written in alphabetical order.
+func ConvertConfigFile(inFile, outFile, format string) error {
+	data, err := ioutil.ReadFile(inFile)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot read configuration file %q", inFile)
+	}
+	if FormatFromExtension(inFile) == FormatYAML {
+		if data, err = yamlToJSON(data); err != nil {
+			return errors.Wrapf(err, "Error parsing configuration file %q",
+				inFile)
+		}
+	}
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep the numbers as written, rather than as float64.
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return errors.Wrapf(err, "Error parsing configuration file %q",
+			inFile)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	configData, err := marshalFormat(config, format)
+	if err != nil {
+		return err
+	}
+	return writeConfigData(configData, outFile)
+}
+
+// marshalConfigJSON encodes config as compact JSON, with UnknownFields after
+// the known fields, in alphabetical order.
+func marshalConfigJSON(config *MenderConfigFromFile) ([]byte, error) {
+	configJson, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error encoding configuration to JSON")
+	}
+	if len(config.UnknownFields) == 0 {
+		return configJson, nil
+	}
+	keys := make([]string, 0, len(config.UnknownFields))
+	for key := range config.UnknownFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimSuffix(configJson, []byte("}")))
+	for _, key := range keys {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		keyJson, err := json.Marshal(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error encoding configuration to JSON")
+		}
+		buf.Write(keyJson)
+		buf.WriteByte(':')
+		buf.Write(config.UnknownFields[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func marshalFormat(config interface{}, format string) ([]byte, error) {
+	configJson, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error encoding configuration to JSON")
+	}
+	switch format {
+	case "", FormatJSON:
+		return configJson, nil
+	case FormatYAML:
+		// JSON is a subset of YAML, and decoding into a node keeps the key
+		// order, which decoding into a map would not.
+		var node yaml.Node
+		if err := yaml.Unmarshal(configJson, &node); err != nil {
+			return nil, errors.Wrap(err, "Error encoding configuration to YAML")
+		}
+		resetYAMLStyle(&node)
+		configYaml, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error encoding configuration to YAML")
+		}
+		return configYaml, nil
+	}
+	return nil, ValidateFormat(format)
+}
+
+// resetYAMLStyle clears the flow and quoting styles inherited from JSON so
+// that the node is emitted as block style YAML.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// yamlToJSON converts a YAML document to JSON, so that it can be decoded
+// with the same field names as a JSON configuration.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixExtension(t *testing.T) {
+	assert.Equal(t, "/a/mender.yaml", FixExtension("/a/mender.conf", FormatYAML))
+	assert.Equal(t, "/a/mender.json", FixExtension("/a/mender.yml", FormatJSON))
+	assert.Equal(t, "/a/mender.conf", FixExtension("/a/mender.conf", FormatJSON))
+	assert.Equal(t, "/a/mender.yaml", FixExtension("/a/mender", FormatYAML))
+}
+
+func TestSaveConfigFileYAML(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "conftest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	config := &MenderConfigFromFile{
+		UpdatePollIntervalSeconds: 1800,
+		TenantToken:               "token",
+		Servers:                   []MenderServer{{ServerURL: "https://acme.mender.io"}},
+	}
+	confPath := path.Join(tmpDir, "mender.yaml")
+	require.NoError(t, SaveConfigFileFormat(config, confPath, FormatYAML))
+	data, err := ioutil.ReadFile(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, "HttpsClient: {}\n"+
+		"Security: {}\n"+
+		"Connectivity: {}\n"+
+		"UpdatePollIntervalSeconds: 1800\n"+
+		"TenantToken: token\n"+
+		"Servers:\n"+
+		"    - ServerURL: https://acme.mender.io\n", string(data))
+
+	loaded, err := LoadConfig(confPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, *config, loaded.MenderConfigFromFile)
+}
+
+func TestMarshalConfigSortedKeys(t *testing.T) {
+	config := &MenderConfigFromFile{
+		UpdatePollIntervalSeconds: 1800,
+		TenantToken:               "token",
+		Servers:                   []MenderServer{{ServerURL: "https://acme.mender.io"}},
+		HttpsClient:               HttpsClient{Certificate: "/cert.pem", Key: "/key.pem"},
+	}
+	data, err := MarshalConfigSortedKeys(config, FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+    "Connectivity": {},
+    "HttpsClient": {
+        "Certificate": "/cert.pem",
+        "Key": "/key.pem"
+    },
+    "Security": {},
+    "Servers": [
+        {
+            "ServerURL": "https://acme.mender.io"
+        }
+    ],
+    "TenantToken": "token",
+    "UpdatePollIntervalSeconds": 1800
+}`, string(data))
+
+	data, err = MarshalConfigSortedKeys(config, FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, "Connectivity: {}\n"+
+		"HttpsClient:\n"+
+		"    Certificate: /cert.pem\n"+
+		"    Key: /key.pem\n"+
+		"Security: {}\n"+
+		"Servers:\n"+
+		"    - ServerURL: https://acme.mender.io\n"+
+		"TenantToken: token\n"+
+		"UpdatePollIntervalSeconds: 1800\n", string(data))
+}
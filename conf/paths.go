@@ -28,21 +28,79 @@ func getenv(key, fallback string) string {
 
 var (
 	// needed so that we can override it when testing or deploying on partially read-only systems
-	DefaultConfFile    = path.Join(GetConfDirPath(), "mender.conf")
-	DefaultPathConfDir = getenv("MENDER_CONF_DIR", "/etc/mender")
-	DefaultDataStore   = getenv("MENDER_DATASTORE_DIR", "/var/lib/mender")
-	DefaultPathDataDir = getenv("MENDER_DATA_DIR", "/usr/share/mender")
+	DefaultConfFile    string
+	DefaultPathConfDir string
+	DefaultDataStore   string
+	DefaultPathDataDir string
 )
 
 var (
 	// device specific paths
-	DefaultArtScriptsPath        = path.Join(GetStateDirPath(), "scripts")
-	DefaultRootfsScriptsPath     = path.Join(GetConfDirPath(), "scripts")
-	DefaultModulesPath           = path.Join(GetDataDirPath(), "modules", "v3")
-	DefaultModulesWorkPath       = path.Join(GetStateDirPath(), "modules", "v3")
-	DefaultBootstrapArtifactFile = path.Join(GetStateDirPath(), "bootstrap.mender")
+	DefaultArtScriptsPath        string
+	DefaultRootfsScriptsPath     string
+	DefaultModulesPath           string
+	DefaultModulesWorkPath       string
+	DefaultBootstrapArtifactFile string
 )
 
+func init() {
+	ReloadPaths()
+}
+
+// ReloadPaths resolves the default paths again from the MENDER_CONF_DIR,
+// MENDER_DATASTORE_DIR and MENDER_DATA_DIR environment variables.
+func ReloadPaths() {
+	SetPrefix("/")
+}
+
+// SetPrefix resolves the default paths for an installation under prefix,
+// e.g. /opt/mender/etc/mender/mender.conf for the prefix /opt/mender. The
+// MENDER_CONF_DIR, MENDER_DATASTORE_DIR and MENDER_DATA_DIR environment
+// variables still take precedence.
+func SetPrefix(prefix string) {
+	DefaultPathConfDir = getenv("MENDER_CONF_DIR",
+		path.Join(prefix, "etc", "mender"))
+	DefaultDataStore = getenv("MENDER_DATASTORE_DIR",
+		path.Join(prefix, "var", "lib", "mender"))
+	DefaultPathDataDir = getenv("MENDER_DATA_DIR",
+		path.Join(prefix, "usr", "share", "mender"))
+	DefaultConfFile = path.Join(GetConfDirPath(), "mender.conf")
+
+	DefaultArtScriptsPath = path.Join(GetStateDirPath(), "scripts")
+	DefaultRootfsScriptsPath = path.Join(GetConfDirPath(), "scripts")
+	DefaultModulesPath = path.Join(GetDataDirPath(), "modules", "v3")
+	DefaultModulesWorkPath = path.Join(GetStateDirPath(), "modules", "v3")
+	DefaultBootstrapArtifactFile = path.Join(GetStateDirPath(), "bootstrap.mender")
+}
+
+// Paths are the resolved default paths, for introspection.
+type Paths struct {
+	ConfDir               string `json:"conf_dir"`
+	ConfFile              string `json:"conf_file"`
+	DataStore             string `json:"data_store"`
+	DataDir               string `json:"data_dir"`
+	ArtScriptsPath        string `json:"art_scripts"`
+	RootfsScriptsPath     string `json:"rootfs_scripts"`
+	ModulesPath           string `json:"modules"`
+	ModulesWorkPath       string `json:"modules_work"`
+	BootstrapArtifactFile string `json:"bootstrap_artifact"`
+}
+
+// DefaultPaths returns the current default paths.
+func DefaultPaths() Paths {
+	return Paths{
+		ConfDir:               DefaultPathConfDir,
+		ConfFile:              DefaultConfFile,
+		DataStore:             DefaultDataStore,
+		DataDir:               DefaultPathDataDir,
+		ArtScriptsPath:        DefaultArtScriptsPath,
+		RootfsScriptsPath:     DefaultRootfsScriptsPath,
+		ModulesPath:           DefaultModulesPath,
+		ModulesWorkPath:       DefaultModulesWorkPath,
+		BootstrapArtifactFile: DefaultBootstrapArtifactFile,
+	}
+}
+
 func GetConfDirPath() string {
 	return DefaultPathConfDir
 }